@@ -1,13 +1,20 @@
 package app
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sync"
 
 	"github.com/ZeljkoBenovic/gombak/pkg/backup"
 	"github.com/ZeljkoBenovic/gombak/pkg/config"
 	"github.com/ZeljkoBenovic/gombak/pkg/discovery"
+	"github.com/ZeljkoBenovic/gombak/pkg/encrypt"
+	"github.com/ZeljkoBenovic/gombak/pkg/gitstore"
 	"github.com/ZeljkoBenovic/gombak/pkg/logger"
+	"github.com/ZeljkoBenovic/gombak/pkg/report"
+	sshclient "github.com/ZeljkoBenovic/gombak/pkg/ssh"
+	"github.com/ZeljkoBenovic/gombak/pkg/storage"
 )
 
 type App struct {
@@ -15,27 +22,48 @@ type App struct {
 	log  *logger.Logger
 	wg   *sync.WaitGroup
 
+	hostKeys    *sshclient.HostKeyStore
+	gitRepo     *gitstore.Repo
 	routersDone *routersDone
 }
 
+// routersDone tracks the routers backed up in the current run, by their file name and unique id
 type routersDone struct {
-	done map[string]struct{}
-	mut  *sync.RWMutex
+	// done maps the router file name to the router unique id
+	done map[string]string
+	mut  *sync.Mutex
 }
 
-func (r *routersDone) isDone(routerName string) bool {
-	r.mut.RLock()
-	if _, ok := r.done[routerName]; ok {
-		r.mut.RUnlock()
-		return true
+// claim registers the router with the identity and unique id, such as serial number or ip address.
+// It returns the name used for the backup files, and whether the router was already backed up in this run,
+// for example when it is discovered through more than one tunnel.
+// The identity belongs to the owner, the router which backups are stored under it, or to the first router that claims it
+// if there is no owner yet. Other routers with the same identity get the unique id appended to the name,
+// so the names do not depend on the order the routers are backed up in.
+func (r *routersDone) claim(identity, id, owner string) (name string, done bool, collision bool) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	name = identity
+
+	if existing, ok := r.done[identity]; (owner != "" && owner != id) || (ok && existing != id) {
+		name = identity + "-" + backup.SanitizeName(id)
+		collision = true
 	}
-	r.mut.RUnlock()
 
+	if existing, ok := r.done[name]; ok {
+		return name, existing == id, collision
+	}
+
+	r.done[name] = id
+
+	return name, false, collision
+}
+
+func (r *routersDone) reset() {
 	r.mut.Lock()
-	r.done[routerName] = struct{}{}
+	r.done = make(map[string]string)
 	r.mut.Unlock()
-
-	return false
 }
 
 func NewApp(conf config.Config, log *logger.Logger) App {
@@ -43,41 +71,61 @@ func NewApp(conf config.Config, log *logger.Logger) App {
 		conf: conf,
 		log:  log,
 		wg:   &sync.WaitGroup{},
+
+		hostKeys: sshclient.NewHostKeyStore(conf.KnownHostsFile, conf.HostKeyMode),
+		gitRepo:  gitstore.New(conf.GitDir),
 		routersDone: &routersDone{
-			done: make(map[string]struct{}),
-			mut:  &sync.RWMutex{},
+			done: make(map[string]string),
+			mut:  &sync.Mutex{},
 		},
 	}
 }
 
 // AppModeFactory returns a worker function based on the configured mode
-func (a App) AppModeFactory() func() error {
+func (a App) AppModeFactory() func(ctx context.Context) error {
 	switch a.conf.Mode {
 	case config.SingleRouter:
-		return func() error {
+		return func(ctx context.Context) error {
 			a.log.Info("Running single mode router backup...")
 
 			if err := a.conf.CheckSingleRequirements(); err != nil {
 				return err
 			}
 
-			if err := a.singleRouterBackup(
-				a.conf.Single.Host,
-				a.conf.Single.Port,
-				a.conf.Single.Username,
-				a.conf.Single.Password,
-			); err != nil {
+			store, err := a.openStorage(ctx)
+			if err != nil {
+				return err
+			}
+
+			defer store.Close()
+
+			run := a.newRun()
+
+			err = a.singleRouterBackup(ctx, run, store, a.configuredRouter(a.conf.Single))
+
+			a.finishRun(ctx, run, store)
+
+			if err != nil {
 				return err
 			}
 
 			a.log.Info("Single router backup complete")
 
-			return backup.RunFileCleanup(a.conf.BackupFolder, a.conf.BackupRetentionDays, a.log)
+			return a.cleanup(ctx, store, run)
 		}
 	case config.MultiRouter:
-		return func() error {
+		return func(ctx context.Context) error {
 			a.log.Info("Running multi router backup mode...")
 
+			store, err := a.openStorage(ctx)
+			if err != nil {
+				return err
+			}
+
+			defer store.Close()
+
+			run := a.newRun()
+
 			for _, mt := range a.conf.Multi {
 				mt := mt
 
@@ -86,7 +134,7 @@ func (a App) AppModeFactory() func() error {
 				go func() {
 					defer a.wg.Done()
 
-					if err := a.singleRouterBackup(mt.Host, mt.Port, mt.Username, mt.Password); err != nil {
+					if err := a.singleRouterBackup(ctx, run, store, a.configuredRouter(mt)); err != nil {
 						a.log.Error("Could not perform backup", "err", err.Error(), "host", mt.Host)
 
 						return
@@ -96,12 +144,18 @@ func (a App) AppModeFactory() func() error {
 
 			a.wg.Wait()
 
+			a.finishRun(ctx, run, store)
+
+			if err := ctx.Err(); err != nil {
+				return fmt.Errorf("backup run aborted: %w", err)
+			}
+
 			a.log.Info("Multi router backup complete")
 
-			return backup.RunFileCleanup(a.conf.BackupFolder, a.conf.BackupRetentionDays, a.log)
+			return a.cleanup(ctx, store, run)
 		}
 	case config.L2TPDiscovery:
-		return func() error {
+		return func(ctx context.Context) error {
 			a.log.Info("Running l2tp discovery mode...")
 
 			if err := a.conf.CheckDiscoveryRequirements(); err != nil {
@@ -125,6 +179,15 @@ func (a App) AppModeFactory() func() error {
 				return err
 			}
 
+			store, err := a.openStorage(ctx)
+			if err != nil {
+				return err
+			}
+
+			defer store.Close()
+
+			run := a.newRun()
+
 			for name, ip := range discRouters {
 				a.wg.Add(1)
 
@@ -134,12 +197,7 @@ func (a App) AppModeFactory() func() error {
 				go func() {
 					defer a.wg.Done()
 
-					if err := a.singleRouterBackup(
-						ip,
-						a.conf.Discovery.SSHPort,
-						a.conf.Discovery.Username,
-						a.conf.Discovery.Password,
-					); err != nil {
+					if err := a.singleRouterBackup(ctx, run, store, a.discoveredRouter(ip)); err != nil {
 						a.log.Error("Could not perform backup", "err", err.Error(), "host", name, "ip", ip)
 						return
 					}
@@ -148,24 +206,431 @@ func (a App) AppModeFactory() func() error {
 
 			a.wg.Wait()
 
+			a.finishRun(ctx, run, store)
+
+			if err := ctx.Err(); err != nil {
+				return fmt.Errorf("backup run aborted: %w", err)
+			}
+
 			a.log.Info("Discovery mode routers backup complete")
 
-			return backup.RunFileCleanup(a.conf.BackupFolder, a.conf.BackupRetentionDays, a.log)
+			return a.cleanup(ctx, store, run)
 		}
 	default:
-		return func() error {
+		return func(_ context.Context) error {
 			return fmt.Errorf("mode not supported")
 		}
 	}
 }
 
-func (a App) singleRouterBackup(host, port, user, pass string) error {
+// router holds the connection and backup settings of a single router
+type router struct {
+	host      string
+	port      string
+	user      string
+	auth      sshclient.ClientOpts
+	jumpHosts []sshclient.JumpHost
+	group     string
+
+	backupPassword   string
+	backupEncryption string
+	export           config.Export
+}
+
+// configuredRouter returns the router settings from the config, falling back to the global settings
+func (a App) configuredRouter(r config.RouterInfo) router {
+	rt := router{
+		host:      r.Host,
+		port:      r.Port,
+		user:      r.Username,
+		auth:      authMethod(r.Password, r.KeyFile, r.KeyPassphrase, r.SSHAgent, r.SSHAgentSocket),
+		jumpHosts: a.jumpHosts(r.JumpHosts),
+		group:     r.Group,
+
+		backupPassword:   a.conf.BackupPassword,
+		backupEncryption: a.conf.BackupEncryption,
+		export:           a.conf.Export,
+	}
+
+	if rt.port == "" {
+		rt.port = "22"
+	}
+
+	if r.BackupPassword != "" {
+		rt.backupPassword = r.BackupPassword
+	}
+
+	if r.BackupEncryption != "" {
+		rt.backupEncryption = r.BackupEncryption
+	}
+
+	if r.Export != nil {
+		rt.export = *r.Export
+	}
+
+	return rt
+}
+
+// discoveredRouter returns the router settings for the discovered ip address, using the discovery settings
+func (a App) discoveredRouter(ip string) router {
+	d := a.conf.Discovery
+
+	return a.configuredRouter(config.RouterInfo{
+		Host:             ip,
+		Port:             d.SSHPort,
+		Username:         d.Username,
+		Password:         d.Password,
+		KeyFile:          d.KeyFile,
+		KeyPassphrase:    d.KeyPassphrase,
+		SSHAgent:         d.SSHAgent,
+		SSHAgentSocket:   d.SSHAgentSocket,
+		Group:            d.Group,
+		JumpHosts:        d.JumpHosts,
+		BackupPassword:   d.BackupPassword,
+		BackupEncryption: d.BackupEncryption,
+		Export:           d.Export,
+	})
+}
+
+// authMethod returns the ssh auth method.
+// A private key takes precedence over the ssh agent, and the ssh agent over the password.
+func authMethod(pass, keyFile, keyPassphrase string, useAgent bool, agentSocket string) sshclient.ClientOpts {
+	switch {
+	case keyFile != "":
+		return sshclient.WithPrivateKeyFile(keyFile, keyPassphrase)
+	case useAgent:
+		return sshclient.WithAgent(agentSocket)
+	default:
+		return sshclient.WithPassword(pass)
+	}
+}
+
+// jumpHosts returns the ssh jump hosts for the router.
+// Router specific jump hosts take precedence over the global ones.
+func (a App) jumpHosts(routerJumpHosts []config.JumpHost) []sshclient.JumpHost {
+	if len(routerJumpHosts) == 0 {
+		routerJumpHosts = a.conf.JumpHosts
+	}
+
+	hosts := make([]sshclient.JumpHost, 0, len(routerJumpHosts))
+
+	for _, j := range routerJumpHosts {
+		port := j.Port
+		if port == "" {
+			port = "22"
+		}
+
+		hosts = append(hosts, sshclient.JumpHost{
+			User: j.Username,
+			Host: j.Host,
+			Port: port,
+			Opts: []sshclient.ClientOpts{
+				authMethod(j.Password, j.KeyFile, j.KeyPassphrase, j.SSHAgent, j.SSHAgentSocket),
+				sshclient.WithHostKeyStore(a.hostKeys),
+				sshclient.WithConnectTimeout(a.conf.Timeouts.Connect),
+			},
+		})
+	}
+
+	return hosts
+}
+
+// newRun starts a new backup run
+func (a App) newRun() *report.Run {
+	a.routersDone.reset()
+
+	return report.New(string(a.conf.Mode))
+}
+
+// retentionPolicy returns the retention policy applied after each run
+func (a App) retentionPolicy() backup.RetentionPolicy {
+	return backup.RetentionPolicy{
+		Days:     a.conf.BackupRetentionDays,
+		KeepLast: a.conf.Retention.KeepLast,
+		Daily:    a.conf.Retention.Daily,
+		Weekly:   a.conf.Retention.Weekly,
+		Monthly:  a.conf.Retention.Monthly,
+		Yearly:   a.conf.Retention.Yearly,
+
+		MinKeep:       a.conf.Retention.MinKeep,
+		FailedRouters: a.conf.Retention.FailedRouters,
+	}
+}
+
+// cleanup applies the retention policy, protecting the backups of the routers which failed in the run
+func (a App) cleanup(ctx context.Context, store storage.Storage, run *report.Run) error {
+	layout, err := backup.ParseLayout(a.conf.Layout)
+	if err != nil {
+		return err
+	}
+
+	var (
+		failed    []string
+		manifests map[string]backup.Manifest
+	)
+
+	for _, rt := range run.FailedRouters() {
+		failed = append(failed, backup.SanitizeName(rt.Host))
+
+		if rt.Identity != "" && rt.ID != "" {
+			failed = append(failed, rt.Identity, backup.SanitizeName(rt.ID))
+			continue
+		}
+
+		// the routers which failed before they were identified are found in their last stored manifest
+		if manifests == nil {
+			if manifests, err = a.lastManifests(ctx, store, layout); err != nil {
+				a.log.Warn("Could not read the stored manifests", "err", err.Error())
+				manifests = make(map[string]backup.Manifest)
+			}
+		}
+
+		m, ok := manifests[rt.Host]
+		if !ok {
+			if !layout.Has(backup.LayoutIP) {
+				a.log.Warn(
+					"Failed router could not be identified - only its latest backups are protected from cleanup",
+					"host", rt.Host,
+				)
+			}
+
+			continue
+		}
+
+		id := m.ID
+		if id == "" {
+			id = m.SerialNumber
+		}
+
+		failed = append(failed, m.Identity)
+
+		if id != "" {
+			failed = append(failed, backup.SanitizeName(id))
+		}
+	}
+
+	if err = backup.RunFileCleanup(ctx, store, layout, a.retentionPolicy(), failed, a.log); err != nil {
+		return err
+	}
+
+	if a.conf.Redact.Enabled && a.conf.Redact.OriginalsDir != "" {
+		originals := storage.NewLocalDir(a.conf.Redact.OriginalsDir)
+		defer originals.Close()
+
+		return backup.RunFileCleanup(ctx, originals, layout, a.retentionPolicy(), failed, a.log)
+	}
+
+	return nil
+}
+
+// lastManifests returns the last stored manifest of each router, by the router host address
+func (a App) lastManifests(ctx context.Context, store storage.Storage, layout *backup.Layout) (map[string]backup.Manifest, error) {
+	var key *encrypt.Key
+
+	if a.conf.EncryptionKeyFile != "" {
+		k, err := encrypt.LoadKey(a.conf.EncryptionKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		key = k
+	}
+
+	return backup.LastManifests(ctx, store, layout, key)
+}
+
+// redactor returns the backup option which redacts the exports, keeping the originals if the originals dir is set
+func (a App) redactor() (backup.Opts, error) {
+	rules := make([]backup.RedactRule, 0, len(a.conf.Redact.Rules))
+
+	for _, r := range a.conf.Redact.Rules {
+		rules = append(rules, backup.RedactRule{
+			Menu:   r.Menu,
+			Params: r.Params,
+		})
+	}
+
+	redactor, err := backup.NewRedactor(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.conf.Redact.OriginalsDir == "" {
+		return backup.WithRedactor(redactor, nil, nil), nil
+	}
+
+	if a.conf.Redact.OriginalsKeyFile == "" {
+		return nil, backup.ErrOriginalsKeyNotSet
+	}
+
+	key, err := encrypt.LoadKey(a.conf.Redact.OriginalsKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return backup.WithRedactor(redactor, storage.NewLocalDir(a.conf.Redact.OriginalsDir), key), nil
+}
+
+// openStorage connects to the configured backup storage
+func (a App) openStorage(ctx context.Context) (storage.Storage, error) {
+	switch a.conf.Storage.Type {
+	case storage.S3:
+		s := a.conf.Storage.S3
+
+		return storage.NewS3Bucket(storage.S3Config{
+			Endpoint:  s.Endpoint,
+			Region:    s.Region,
+			Bucket:    s.Bucket,
+			Prefix:    s.Prefix,
+			AccessKey: s.AccessKey,
+			SecretKey: s.SecretKey,
+			Insecure:  s.Insecure,
+		})
+	case storage.SFTP:
+		s := a.conf.Storage.SFTP
+
+		port := s.Port
+		if port == "" {
+			port = "22"
+		}
+
+		return storage.NewSFTPDir(
+			ctx,
+			storage.SFTPConfig{
+				Host: s.Host,
+				Port: port,
+				User: s.Username,
+				Dir:  s.Dir,
+			},
+			authMethod(s.Password, s.KeyFile, s.KeyPassphrase, s.SSHAgent, s.SSHAgentSocket),
+			sshclient.WithHostKeyStore(a.hostKeys),
+			sshclient.WithConnectTimeout(a.conf.Timeouts.Connect),
+		)
+	default:
+		return storage.NewLocalDir(a.conf.BackupFolder), nil
+	}
+}
+
+// finishRun stores the run report and logs the run summary
+func (a App) finishRun(ctx context.Context, run *report.Run, store storage.Storage) {
+	// the report is stored even if the run was aborted
+	file, err := run.Finish(context.WithoutCancel(ctx), store)
+	if err != nil {
+		a.log.Error("Could not write run report", "err", err.Error(), "run_id", run.ID)
+	}
+
+	a.log.Info(
+		"Backup run finished",
+		"run_id", run.ID,
+		"routers", len(run.Routers),
+		"failed", run.Failed(),
+		"report", file,
+	)
+}
+
+// singleRouterBackup runs the backup of a single router and adds its result to the run report
+func (a App) singleRouterBackup(ctx context.Context, run *report.Run, store storage.Storage, r router) error {
+	result := report.Router{
+		Host: r.host,
+	}
+
+	err := a.routerBackup(ctx, run.ID, store, r, &result)
+	if errors.Is(err, errAlreadyDone) {
+		return nil
+	}
+
+	var spaceErr *backup.InsufficientSpaceError
+
+	switch {
+	case errors.As(err, &spaceErr):
+		result.Error = err.Error()
+		result.ErrorType = report.ErrorInsufficientSpace
+	case err != nil:
+		result.Error = err.Error()
+	default:
+		result.Success = true
+	}
+
+	run.Add(result)
+
+	return err
+}
+
+var errAlreadyDone = errors.New("router backup already done")
+
+func (a App) routerBackup(ctx context.Context, runID string, store storage.Storage, r router, result *report.Router) error {
+	opts := []backup.Opts{
+		backup.WithSSHOpts(
+			sshclient.WithHostKeyStore(a.hostKeys),
+			sshclient.WithJumpHosts(r.jumpHosts...),
+			sshclient.WithConnectTimeout(a.conf.Timeouts.Connect),
+		),
+		backup.WithCommandTimeout(a.conf.Timeouts.Command),
+		backup.WithTransferTimeout(a.conf.Timeouts.Transfer),
+		backup.WithVerifyRetries(a.conf.VerifyRetries),
+		backup.WithRunID(runID),
+		backup.WithUnchangedExport(a.conf.UnchangedExport),
+		backup.WithCompression(a.conf.Compression),
+		backup.WithExtraArtifacts(backup.ExtraArtifacts{
+			Users:                 a.conf.Artifacts.Users,
+			Certificates:          a.conf.Artifacts.Certificates,
+			CertificatePassphrase: a.conf.Artifacts.CertificatePassphrase,
+			Scripts:               a.conf.Artifacts.Scripts,
+			Files:                 a.conf.Artifacts.Files,
+		}),
+		backup.WithExportFlags(backup.ExportFlags{
+			ShowSensitive: r.export.ShowSensitive,
+			Compact:       r.export.Compact,
+			Verbose:       r.export.Verbose,
+			Terse:         r.export.Terse,
+		}),
+	}
+
+	if a.conf.StreamExport {
+		opts = append(opts, backup.WithStreamExport())
+	}
+
+	if a.conf.ExportStorage == config.GitStorage {
+		opts = append(opts, backup.WithGitRepo(a.gitRepo))
+	}
+
+	if r.backupPassword != "" {
+		opts = append(opts, backup.WithBackupPassword(r.backupPassword, r.backupEncryption))
+	}
+
+	layout, err := backup.ParseLayout(a.conf.Layout)
+	if err != nil {
+		return err
+	}
+
+	opts = append(opts, backup.WithLayout(layout), backup.WithGroup(r.group))
+
+	if a.conf.EncryptionKeyFile != "" {
+		key, err := encrypt.LoadKey(a.conf.EncryptionKeyFile)
+		if err != nil {
+			return err
+		}
+
+		opts = append(opts, backup.WithEncryptionKey(key))
+	}
+
+	if a.conf.Redact.Enabled {
+		redactOpt, err := a.redactor()
+		if err != nil {
+			return err
+		}
+
+		opts = append(opts, redactOpt)
+	}
+
 	bck, err := backup.New(
-		host,
-		port,
-		user,
-		pass,
+		ctx,
+		r.host,
+		r.port,
+		r.user,
+		r.auth,
 		a.log,
+		opts...,
 	)
 	if err != nil {
 		return err
@@ -173,19 +638,50 @@ func (a App) singleRouterBackup(host, port, user, pass string) error {
 
 	defer bck.Close()
 
-	routerName, err := bck.GetRouterIdentity()
+	// stale temp files are removed before the backup, as they can fill the router storage and make it fail
+	if err = bck.DeleteStaleTempFiles(ctx, a.conf.StaleTempFilesAge); err != nil {
+		a.log.Warn("Could not delete stale temp files", "err", err.Error(), "host", r.host)
+	}
+
+	identity, err := bck.GetRouterIdentity(ctx)
 	if err != nil {
 		return err
 	}
 
+	id := bck.RouterID(ctx)
+
+	routerName, done, collision := a.routersDone.claim(identity, id, bck.StoredID(ctx, store))
+
 	// Skip work if already done
-	if a.routersDone.isDone(routerName) {
-		return nil
+	if done {
+		a.log.Debug("Router already backed up in this run", "identity", identity, "id", id, "host", r.host)
+		return errAlreadyDone
 	}
 
-	if err := bck.RunBackup(a.conf.BackupFolder); err != nil {
-		return err
+	if collision {
+		a.log.Warn(
+			"Router identity already used by another router - using unique name",
+			"identity", identity,
+			"name", routerName,
+			"id", id,
+			"host", r.host,
+		)
+
+		bck.SetName(routerName)
 	}
 
-	return bck.DeleteTempFiles()
+	result.Identity = routerName
+	result.ID = id
+
+	// temp files are removed also when the backup fails
+	defer func() {
+		if err := bck.DeleteTempFiles(ctx); err != nil {
+			a.log.Warn("Could not delete temp files", "err", err.Error(), "host", r.host)
+		}
+	}()
+
+	err = bck.RunBackup(ctx, store)
+	result.Artifacts = bck.Artifacts()
+
+	return err
 }
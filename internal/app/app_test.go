@@ -0,0 +1,96 @@
+package app
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestRoutersDoneClaim(t *testing.T) {
+	type claim struct {
+		identity, id, owner string
+
+		wantName      string
+		wantDone      bool
+		wantCollision bool
+	}
+
+	tests := []struct {
+		name   string
+		claims []claim
+	}{
+		{
+			name: "router discovered twice",
+			claims: []claim{
+				{identity: "router1", id: "HB1234", wantName: "router1"},
+				{identity: "router1", id: "HB1234", wantName: "router1", wantDone: true},
+			},
+		},
+		{
+			name: "different identities",
+			claims: []claim{
+				{identity: "router1", id: "HB1234", wantName: "router1"},
+				{identity: "router2", id: "HC5678", wantName: "router2"},
+			},
+		},
+		{
+			name: "same identity without an owner goes to the first router",
+			claims: []claim{
+				{identity: "MikroTik", id: "HB1234", wantName: "MikroTik"},
+				{identity: "MikroTik", id: "10.0.0.2", wantName: "MikroTik-10.0.0.2", wantCollision: true},
+				{identity: "MikroTik", id: "10.0.0.2", wantName: "MikroTik-10.0.0.2", wantDone: true, wantCollision: true},
+			},
+		},
+		{
+			name: "owner keeps the identity when it is backed up last",
+			claims: []claim{
+				{identity: "MikroTik", id: "HC5678", owner: "HB1234", wantName: "MikroTik-HC5678", wantCollision: true},
+				{identity: "MikroTik", id: "HB1234", owner: "HB1234", wantName: "MikroTik"},
+			},
+		},
+		{
+			name: "owner keeps the identity when it is backed up first",
+			claims: []claim{
+				{identity: "MikroTik", id: "HB1234", owner: "HB1234", wantName: "MikroTik"},
+				{identity: "MikroTik", id: "HC5678", owner: "HB1234", wantName: "MikroTik-HC5678", wantCollision: true},
+			},
+		},
+		{
+			name: "id is sanitized in the name",
+			claims: []claim{
+				{identity: "router1", id: "HB1234", wantName: "router1"},
+				{identity: "router1", id: "fe80::1/64", wantName: "router1-fe80-1-64", wantCollision: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &routersDone{
+				done: make(map[string]string),
+				mut:  &sync.Mutex{},
+			}
+
+			for i, c := range tt.claims {
+				name, done, collision := r.claim(c.identity, c.id, c.owner)
+				if name != c.wantName || done != c.wantDone || collision != c.wantCollision {
+					t.Errorf("claim %d (%s, %s, %s) = %q, done %v, collision %v, want %q, done %v, collision %v",
+						i, c.identity, c.id, c.owner, name, done, collision, c.wantName, c.wantDone, c.wantCollision)
+				}
+			}
+		})
+	}
+}
+
+func TestRoutersDoneReset(t *testing.T) {
+	r := &routersDone{
+		done: make(map[string]string),
+		mut:  &sync.Mutex{},
+	}
+
+	r.claim("router1", "HB1234", "")
+	r.reset()
+
+	if _, done, _ := r.claim("router1", "HB1234", ""); done {
+		t.Error("claim() after reset() reported the router done, want not done")
+	}
+}
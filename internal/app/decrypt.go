@@ -0,0 +1,77 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ZeljkoBenovic/gombak/pkg/compress"
+	"github.com/ZeljkoBenovic/gombak/pkg/encrypt"
+)
+
+var (
+	ErrFileNotSet          = errors.New("backup file not set, use --file")
+	ErrEncryptionKeyNotSet = errors.New("encryption key file not set, use --encryption-key-file")
+)
+
+// Decrypt decrypts the backup file and decompresses it if it is compressed.
+// The output is written to a new file, named after the backup file without the encryption and compression extensions by default.
+func (a App) Decrypt() error {
+	file := a.conf.Command.File
+	if file == "" {
+		return ErrFileNotSet
+	}
+
+	if a.conf.EncryptionKeyFile == "" {
+		return ErrEncryptionKeyNotSet
+	}
+
+	key, err := encrypt.LoadKey(a.conf.EncryptionKeyFile)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("could not read backup file: %w", err)
+	}
+
+	if data, err = key.Decrypt(data); err != nil {
+		return err
+	}
+
+	name := strings.TrimSuffix(file, encrypt.Ext)
+
+	if data, err = compress.Decompress(name, data); err != nil {
+		return err
+	}
+
+	out := a.conf.Command.Out
+	if out == "" {
+		out = compress.TrimExt(name)
+	}
+
+	if out == file {
+		return fmt.Errorf("output file %s is the same as the backup file", out)
+	}
+
+	// existing files are never overwritten
+	f, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("could not create output file: %w", err)
+	}
+
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("could not write output file: %w", err)
+	}
+
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("could not write output file: %w", err)
+	}
+
+	a.log.Info("Backup file decrypted", "file", file, "out", out)
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ZeljkoBenovic/gombak/pkg/backup"
+	"github.com/ZeljkoBenovic/gombak/pkg/compress"
+	"github.com/ZeljkoBenovic/gombak/pkg/encrypt"
+	sshclient "github.com/ZeljkoBenovic/gombak/pkg/ssh"
+)
+
+var (
+	ErrHostNotSet          = errors.New("router host not set, use --host")
+	ErrRestoreNotConfirmed = errors.New("restore overwrites the router configuration, use --confirm to restore or --dry-run to preview")
+)
+
+// Restore uploads the backup file to the router and loads it.
+// The router credentials are taken from the single mode settings, and the host can be overridden with --host.
+func (a App) Restore(ctx context.Context) error {
+	file := a.conf.Command.File
+	if file == "" {
+		return ErrFileNotSet
+	}
+
+	if !a.conf.Command.Confirm && !a.conf.Command.DryRun {
+		return ErrRestoreNotConfirmed
+	}
+
+	conf := a.conf
+	if conf.Command.Host != "" {
+		conf.Single.Host = conf.Command.Host
+	}
+
+	if conf.Single.Host == "" {
+		return ErrHostNotSet
+	}
+
+	if err := conf.CheckSingleRequirements(); err != nil {
+		return err
+	}
+
+	name, data, err := a.loadBackupFile(file)
+	if err != nil {
+		return err
+	}
+
+	if path.Ext(name) == ".rsc" && backup.IsRedacted(data) &&
+		a.conf.Redact.OriginalsDir != "" && a.conf.Redact.OriginalsKeyFile != "" {
+		if name, data, err = a.loadOriginalExport(name); err != nil {
+			return err
+		}
+	}
+
+	r := a.configuredRouter(conf.Single)
+
+	opts := []backup.Opts{
+		backup.WithSSHOpts(
+			sshclient.WithHostKeyStore(a.hostKeys),
+			sshclient.WithJumpHosts(r.jumpHosts...),
+			sshclient.WithConnectTimeout(a.conf.Timeouts.Connect),
+		),
+		backup.WithCommandTimeout(a.conf.Timeouts.Command),
+		backup.WithTransferTimeout(a.conf.Timeouts.Transfer),
+	}
+
+	if r.backupPassword != "" {
+		opts = append(opts, backup.WithBackupPassword(r.backupPassword, r.backupEncryption))
+	}
+
+	bck, err := backup.New(ctx, r.host, r.port, r.user, r.auth, a.log, opts...)
+	if err != nil {
+		return err
+	}
+
+	defer bck.Close()
+
+	if _, err = bck.GetRouterIdentity(ctx); err != nil {
+		return err
+	}
+
+	return bck.Restore(ctx, name, data, a.conf.Command.DryRun)
+}
+
+// loadBackupFile reads the stored backup file, and decrypts and decompresses it if needed.
+// It returns the file name without the encryption and compression extensions.
+func (a App) loadBackupFile(file string) (string, []byte, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", nil, fmt.Errorf("could not read backup file: %w", err)
+	}
+
+	name := file
+
+	if strings.HasSuffix(name, encrypt.Ext) {
+		if a.conf.EncryptionKeyFile == "" {
+			return "", nil, ErrEncryptionKeyNotSet
+		}
+
+		key, err := encrypt.LoadKey(a.conf.EncryptionKeyFile)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if data, err = key.Decrypt(data); err != nil {
+			return "", nil, err
+		}
+
+		name = strings.TrimSuffix(name, encrypt.Ext)
+	}
+
+	if data, err = compress.Decompress(name, data); err != nil {
+		return "", nil, err
+	}
+
+	return compress.TrimExt(name), data, nil
+}
+
+// loadOriginalExport reads the unredacted copy of the redacted export from the originals dir, and decrypts and decompresses it.
+// The name is the redacted export file name without the encryption and compression extensions,
+// and the copy is looked up at the same path relative to the originals dir as the export relative to the backup dir.
+func (a App) loadOriginalExport(name string) (string, []byte, error) {
+	rel, err := filepath.Rel(a.conf.BackupFolder, name)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", nil, fmt.Errorf("%w: %s is not in the backup dir", backup.ErrRedactedExport, name)
+	}
+
+	key, err := encrypt.LoadKey(a.conf.Redact.OriginalsKeyFile)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// the original is compressed with the compression set when it was stored
+	for _, algorithm := range []compress.Algorithm{compress.None, compress.Gzip, compress.Zstd} {
+		original := filepath.Join(a.conf.Redact.OriginalsDir, rel) + algorithm.Ext() + encrypt.Ext
+
+		data, err := os.ReadFile(original)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return "", nil, fmt.Errorf("could not read original export: %w", err)
+		}
+
+		if data, err = key.Decrypt(data); err != nil {
+			return "", nil, err
+		}
+
+		if data, err = compress.Decompress(strings.TrimSuffix(original, encrypt.Ext), data); err != nil {
+			return "", nil, err
+		}
+
+		a.log.Info("Export is redacted - restoring the original export", "file_name", original)
+
+		return compress.TrimExt(strings.TrimSuffix(original, encrypt.Ext)), data, nil
+	}
+
+	return "", nil, fmt.Errorf("%w: original of %s not found in %s", backup.ErrRedactedExport, name, a.conf.Redact.OriginalsDir)
+}
@@ -0,0 +1,36 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/ZeljkoBenovic/gombak/pkg/backup"
+)
+
+// Validate checks the settings which are defined by the backup package, so an invalid configuration fails at startup
+// instead of on every router. The other settings are validated when the configuration is loaded.
+func (a App) Validate() error {
+	if _, err := backup.ParseLayout(a.conf.Layout); err != nil {
+		return err
+	}
+
+	if _, ok := backup.UnchangedExportModes[a.conf.UnchangedExport]; a.conf.UnchangedExport != "" && !ok {
+		return fmt.Errorf("unchanged export mode %s not supported", a.conf.UnchangedExport)
+	}
+
+	encryptions := []string{a.conf.BackupEncryption, a.conf.Single.BackupEncryption, a.conf.Discovery.BackupEncryption}
+	for _, r := range a.conf.Multi {
+		encryptions = append(encryptions, r.BackupEncryption)
+	}
+
+	for _, e := range encryptions {
+		if _, ok := backup.BackupEncryptions[e]; e != "" && !ok {
+			return fmt.Errorf("backup encryption %s not supported", e)
+		}
+	}
+
+	if _, ok := backup.FailedRoutersModes[a.conf.Retention.FailedRouters]; a.conf.Retention.FailedRouters != "" && !ok {
+		return fmt.Errorf("failed routers mode %s not supported", a.conf.Retention.FailedRouters)
+	}
+
+	return nil
+}
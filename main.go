@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/ZeljkoBenovic/gombak/internal/app"
 	"github.com/ZeljkoBenovic/gombak/pkg/config"
@@ -19,7 +22,38 @@ func main() {
 		os.Exit(1)
 	}
 
-	run := app.NewApp(conf, log).AppModeFactory()
+	gombak := app.NewApp(conf, log)
+
+	if err = gombak.Validate(); err != nil {
+		log.Error("config error", "err", err)
+
+		os.Exit(1)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "decrypt" {
+		if err = gombak.Decrypt(); err != nil {
+			log.Error("decrypt error", "err", err)
+
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		if err = gombak.Restore(ctx); err != nil {
+			log.Error("restore error", "err", err)
+
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	run := gombak.AppModeFactory()
 
 	srv, err := service.New(conf, []string{"run", "-c", conf.ConfigFilePath}, log)
 	if err != nil {
@@ -35,7 +69,10 @@ func main() {
 	}
 
 	if !isService {
-		if err = run(); err != nil {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		if err = run(ctx); err != nil {
 			log.Error("run error", "err", err)
 
 			os.Exit(1)
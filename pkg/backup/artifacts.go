@@ -0,0 +1,436 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/ZeljkoBenovic/gombak/pkg/report"
+)
+
+var ErrCertificatePassphraseNotSet = errors.New("certificate export requires a passphrase")
+
+// ExtraArtifacts are the router files backed up next to the export and the binary backup
+type ExtraArtifacts struct {
+	// Users stores the /user export
+	Users bool
+	// Certificates stores each certificate with its private key, as a PKCS#12 file encrypted with CertificatePassphrase
+	Certificates          bool
+	CertificatePassphrase string
+	// Scripts stores the export of each /system script in its own file
+	Scripts bool
+	// Files are the glob patterns of the router files stored in a tar archive, matched against the path
+	// relative to the router storage root. All files in a matched directory are stored.
+	Files []string
+}
+
+// WithExtraArtifacts backs up the extra artifacts, after the export and the binary backup
+func WithExtraArtifacts(extra ExtraArtifacts) Opts {
+	return func(b *Backup) {
+		b.extraArtifacts = extra
+	}
+}
+
+// validate checks the extra artifacts settings
+func (e ExtraArtifacts) validate() error {
+	if e.Certificates && e.CertificatePassphrase == "" {
+		return ErrCertificatePassphraseNotSet
+	}
+
+	for _, pattern := range e.Files {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid file pattern %s: %w", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// backupExtraArtifacts fetches the extra artifacts from the router and stores them, using name as the base of the stored file names.
+// The artifacts are fetched on a best effort basis: the artifacts which fail are logged and recorded in the run report,
+// and the backup continues. It fails only if the context is done.
+func (b *Backup) backupExtraArtifacts(ctx context.Context, name string) error {
+	if b.extraArtifacts.Users {
+		if err := b.backupUsers(ctx, name); err != nil {
+			b.artifactFailed("users", err)
+		}
+	}
+
+	if b.extraArtifacts.Scripts {
+		if err := b.backupScripts(ctx, name); err != nil {
+			b.artifactFailed("scripts", err)
+		}
+	}
+
+	if b.extraArtifacts.Certificates {
+		if err := b.backupCertificates(ctx, name); err != nil {
+			b.artifactFailed("certificates", err)
+		}
+	}
+
+	if len(b.extraArtifacts.Files) > 0 {
+		if err := b.backupFiles(ctx, name); err != nil {
+			b.artifactFailed("files", err)
+		}
+	}
+
+	return ctx.Err()
+}
+
+// artifactFailed logs the extra artifact which could not be backed up, and records it in the run report
+func (b *Backup) artifactFailed(artifact string, err error) {
+	b.log.Warn("Could not back up artifact", "artifact", artifact, "err", err.Error(), "host", b.host)
+
+	b.artifacts = append(b.artifacts, report.Artifact{
+		Name:  artifact,
+		Error: err.Error(),
+	})
+}
+
+// exportTo exports the RouterOS menu, limited by the where condition if it is set, and writes the export to w.
+// The export is streamed over the ssh session if stream export is set, otherwise it is written to the temp file with the suffix
+// and downloaded.
+func (b *Backup) exportTo(ctx context.Context, menu, where, suffix string, w *bytes.Buffer) error {
+	cmd := menu + " export"
+
+	// where takes the rest of the command, so it must be the last parameter
+	if where != "" {
+		where = " where " + where
+	}
+
+	if b.streamExport {
+		return b.runTo(ctx, cmd+where, w)
+	}
+
+	file := b.remoteFile(suffix + ".rsc")
+
+	if _, err := b.run(ctx, fmt.Sprintf("%s file=%s%s", cmd, strings.TrimPrefix(file, "/"), where)); err != nil {
+		return err
+	}
+
+	defer b.deleteTempFile(ctx, file)
+
+	return b.download(ctx, file, w)
+}
+
+// deleteTempFile removes the temp file from the router, logging the error if it fails
+func (b *Backup) deleteTempFile(ctx context.Context, file string) {
+	if err := b.deleteFile(ctx, file); err != nil {
+		b.log.Error(
+			"Backup file on the router could not be deleted",
+			"err", err.Error(),
+			"file_name", file,
+			"host", b.host,
+		)
+	}
+}
+
+// listNames returns the names of the items in the RouterOS menu, such as /system script
+func (b *Backup) listNames(ctx context.Context, menu string) ([]string, error) {
+	out, err := b.run(ctx, fmt.Sprintf(":foreach i in=[%s find] do={:put [%s get $i name]}", menu, menu))
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			names = append(names, line)
+		}
+	}
+
+	return names, nil
+}
+
+// uniqueNames returns a file name safe and unique version of each name
+func uniqueNames(names []string) []string {
+	var (
+		unique = make([]string, len(names))
+		used   = make(map[string]bool)
+	)
+
+	for i, n := range names {
+		n = SanitizeName(n)
+		if n == "" {
+			n = strconv.Itoa(i + 1)
+		}
+
+		for candidate, suffix := n, 2; ; suffix++ {
+			if !used[candidate] {
+				n = candidate
+				break
+			}
+
+			candidate = fmt.Sprintf("%s-%d", n, suffix)
+		}
+
+		used[n] = true
+		unique[i] = n
+	}
+
+	return unique
+}
+
+func (b *Backup) backupUsers(ctx context.Context, name string) error {
+	b.log.Debug("Exporting users", "host", b.host)
+
+	var users bytes.Buffer
+
+	if err := b.exportTo(ctx, "/user", "", "-users", &users); err != nil {
+		return fmt.Errorf("could not export users: %w", err)
+	}
+
+	content, err := b.redactExport(ctx, "users", name+".users.rsc", users.Bytes())
+	if err != nil {
+		return err
+	}
+
+	location, err := b.put(ctx, b.storedName(name+".users.rsc"), content)
+	if err != nil {
+		return err
+	}
+
+	b.artifacts = append(b.artifacts, report.Artifact{
+		Name:      "users",
+		Path:      location,
+		Encrypted: b.encryptionKey != nil,
+		Size:      int64(users.Len()),
+	})
+
+	return nil
+}
+
+func (b *Backup) backupScripts(ctx context.Context, name string) error {
+	scripts, err := b.listNames(ctx, "/system script")
+	if err != nil {
+		return fmt.Errorf("could not list scripts: %w", err)
+	}
+
+	b.log.Debug("Exporting scripts", "count", len(scripts), "host", b.host)
+
+	for i, fileName := range uniqueNames(scripts) {
+		if err = b.backupScript(ctx, name, scripts[i], fileName, i); err != nil {
+			b.artifactFailed("script/"+scripts[i], err)
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// backupScript exports and stores the script, using fileName in the stored file name and the index in the router temp file name
+func (b *Backup) backupScript(ctx context.Context, name, script, fileName string, index int) error {
+	var export bytes.Buffer
+
+	where := "name=" + quote(script)
+
+	if err := b.exportTo(ctx, "/system script", where, "-script-"+strconv.Itoa(index), &export); err != nil {
+		return fmt.Errorf("could not export script %s: %w", script, err)
+	}
+
+	content, err := b.redactExport(ctx, "script/"+script, name+".script-"+fileName+".rsc", export.Bytes())
+	if err != nil {
+		return err
+	}
+
+	location, err := b.put(ctx, b.storedName(name+".script-"+fileName+".rsc"), content)
+	if err != nil {
+		return err
+	}
+
+	b.artifacts = append(b.artifacts, report.Artifact{
+		Name:      "script/" + script,
+		Path:      location,
+		Encrypted: b.encryptionKey != nil,
+		Size:      int64(export.Len()),
+	})
+
+	return nil
+}
+
+func (b *Backup) backupCertificates(ctx context.Context, name string) error {
+	certs, err := b.listNames(ctx, "/certificate")
+	if err != nil {
+		return fmt.Errorf("could not list certificates: %w", err)
+	}
+
+	b.log.Debug("Exporting certificates", "count", len(certs), "host", b.host)
+
+	for i, fileName := range uniqueNames(certs) {
+		if err = b.backupCertificate(ctx, name, certs[i], fileName, i); err != nil {
+			b.artifactFailed("certificate/"+certs[i], err)
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// backupCertificate exports the certificate with its private key as a PKCS#12 file and stores it,
+// using fileName in the stored file name and the index in the router temp file name
+func (b *Backup) backupCertificate(ctx context.Context, name, cert, fileName string, index int) error {
+	// RouterOS appends the .p12 extension to the file name
+	file := b.remoteFile("-cert-" + strconv.Itoa(index))
+
+	cmd := fmt.Sprintf(
+		"/certificate export-certificate [find name=%s] type=pkcs12 export-passphrase=%s file-name=%s",
+		quote(cert),
+		quote(b.extraArtifacts.CertificatePassphrase),
+		strings.TrimPrefix(file, "/"),
+	)
+
+	if _, err := b.run(ctx, cmd); err != nil {
+		return fmt.Errorf("could not export certificate %s: %w", cert, err)
+	}
+
+	var content bytes.Buffer
+
+	err := b.download(ctx, file+".p12", &content)
+	b.deleteTempFile(ctx, file+".p12")
+
+	if err != nil {
+		return fmt.Errorf("could not download certificate %s: %w", cert, err)
+	}
+
+	location, err := b.put(ctx, b.storedName(name+".cert-"+fileName+".p12"), content.Bytes())
+	if err != nil {
+		return err
+	}
+
+	b.artifacts = append(b.artifacts, report.Artifact{
+		Name:      "certificate/" + cert,
+		Path:      location,
+		Encrypted: true,
+		Size:      int64(content.Len()),
+	})
+
+	return nil
+}
+
+// matchFile reports whether the router file path, or one of its parent directories, matches one of the patterns
+func matchFile(patterns []string, file string) bool {
+	for p := file; p != "." && p != "/"; p = path.Dir(p) {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(strings.TrimPrefix(pattern, "/"), p); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// backupFiles stores the router files matching the file patterns in a tar archive
+func (b *Backup) backupFiles(ctx context.Context, name string) error {
+	// the archive and the downloaded files are kept in local temp files, as the router files can be large
+	archive, err := createLocalTemp()
+	if err != nil {
+		return err
+	}
+
+	defer removeLocalTemp(archive)
+
+	content, err := createLocalTemp()
+	if err != nil {
+		return err
+	}
+
+	defer removeLocalTemp(content)
+
+	var count int
+
+	tw := tar.NewWriter(archive)
+
+	err = b.cl.Walk(ctx, "/", func(remote string, info os.FileInfo) error {
+		file := strings.TrimPrefix(remote, "/")
+
+		if info.IsDir() || strings.HasPrefix(path.Base(file), TempFilePrefix) || !matchFile(b.extraArtifacts.Files, file) {
+			return nil
+		}
+
+		b.log.Debug("Downloading file", "name", file, "host", b.host)
+
+		if err := resetLocalTemp(content); err != nil {
+			return err
+		}
+
+		// an unreadable file is left out of the archive, unless the backup was cancelled
+		if err := b.download(ctx, remote, content); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			b.artifactFailed("file/"+file, fmt.Errorf("could not download %s: %w", file, err))
+
+			return nil
+		}
+
+		// the downloaded size is archived, as the file can change between the listing and the download
+		size, err := content.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return fmt.Errorf("could not archive %s: %w", file, err)
+		}
+
+		if err = tw.WriteHeader(&tar.Header{
+			Name:    file,
+			Mode:    0o644,
+			Size:    size,
+			ModTime: info.ModTime(),
+		}); err != nil {
+			return fmt.Errorf("could not archive %s: %w", file, err)
+		}
+
+		if err = copyFrom(tw, content); err != nil {
+			return fmt.Errorf("could not archive %s: %w", file, err)
+		}
+
+		count++
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not back up router files: %w", err)
+	}
+
+	if count == 0 {
+		b.log.Warn("No router files match the file patterns", "patterns", b.extraArtifacts.Files, "host", b.host)
+		return nil
+	}
+
+	if err = tw.Close(); err != nil {
+		return fmt.Errorf("could not archive router files: %w", err)
+	}
+
+	archiveInfo, err := archive.Stat()
+	if err != nil {
+		return fmt.Errorf("could not stat %s: %w", archive.Name(), err)
+	}
+
+	location, err := b.putFile(ctx, b.storedName(name+".files.tar"), archive)
+	if err != nil {
+		return err
+	}
+
+	b.artifacts = append(b.artifacts, report.Artifact{
+		Name:      "files",
+		Path:      location,
+		Encrypted: b.encryptionKey != nil,
+		Size:      archiveInfo.Size(),
+	})
+
+	return nil
+}
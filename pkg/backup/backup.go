@@ -1,194 +1,735 @@
 package backup
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
-	"io/fs"
+	"io"
 	"os"
 	"path"
-	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/ZeljkoBenovic/gombak/pkg/compress"
+	"github.com/ZeljkoBenovic/gombak/pkg/encrypt"
+	"github.com/ZeljkoBenovic/gombak/pkg/gitstore"
 	"github.com/ZeljkoBenovic/gombak/pkg/logger"
+	"github.com/ZeljkoBenovic/gombak/pkg/report"
 	sshclient "github.com/ZeljkoBenovic/gombak/pkg/ssh"
+	"github.com/ZeljkoBenovic/gombak/pkg/storage"
 )
 
+// ChecksumExt is the extension of the sha256sum compatible file stored next to each artifact
+const ChecksumExt = ".sha256"
+
 type Backup struct {
-	backupDir string
-	cl        *sshclient.SSH
-	log       *logger.Logger
+	store storage.Storage
+	cl    *sshclient.SSH
+	log   *logger.Logger
 
 	host   string
 	hostIP string
+
+	sshOpts []sshclient.ClientOpts
+
+	commandTimeout  time.Duration
+	transferTimeout time.Duration
+
+	streamExport bool
+	exportFlags  ExportFlags
+	osVersion    RouterOSVersion
+
+	backupPassword   string
+	backupEncryption string
+
+	artifacts []report.Artifact
+
+	// tempName is the unique name of the files created on the router during this backup
+	tempName string
+	runID    string
+	serial   string
+
+	unchangedExport string
+	gitRepo         *gitstore.Repo
+	compression     compress.Algorithm
+	encryptionKey   *encrypt.Key
+
+	layout *Layout
+	group  string
+
+	extraArtifacts ExtraArtifacts
+
+	redactor     *Redactor
+	originals    storage.Storage
+	originalsKey *encrypt.Key
+
+	verifyRetries int
+}
+
+// BackupEncryptions are the encryption types supported by the RouterOS binary backup.
+// rc4 is the only one available before RouterOS 6.43.
+var BackupEncryptions = map[string]struct{}{
+	"aes-sha256": {},
+	"rc4":        {},
+}
+
+type Opts func(*Backup)
+
+// WithBackupPassword encrypts the binary backup with the password, using the encryption type from BackupEncryptions.
+// If encryption is empty, RouterOS chooses the default.
+func WithBackupPassword(password, encryption string) Opts {
+	return func(b *Backup) {
+		b.backupPassword = password
+		b.backupEncryption = encryption
+	}
+}
+
+// WithCommandTimeout limits the time each command run on the router can take
+func WithCommandTimeout(timeout time.Duration) Opts {
+	return func(b *Backup) {
+		b.commandTimeout = timeout
+	}
+}
+
+// WithTransferTimeout limits the time each file download can take
+func WithTransferTimeout(timeout time.Duration) Opts {
+	return func(b *Backup) {
+		b.transferTimeout = timeout
+	}
+}
+
+// WithSSHOpts sets additional ssh client options, such as host key verification
+func WithSSHOpts(opts ...sshclient.ClientOpts) Opts {
+	return func(b *Backup) {
+		b.sshOpts = append(b.sshOpts, opts...)
+	}
+}
+
+// WithRunID includes the run id in the names of the files created on the router
+func WithRunID(id string) Opts {
+	return func(b *Backup) {
+		b.runID = id
+	}
+}
+
+// WithStreamExport captures the /export output over the ssh session, instead of writing it to the router storage first
+func WithStreamExport() Opts {
+	return func(b *Backup) {
+		b.streamExport = true
+	}
+}
+
+// WithCompression compresses the stored backup files, which get the compression extension appended to their names
+func WithCompression(alg compress.Algorithm) Opts {
+	return func(b *Backup) {
+		b.compression = alg
+	}
 }
 
-func New(host, port, user, pass string, log *logger.Logger) (*Backup, error) {
+// WithEncryptionKey encrypts the stored backup files with the key, after they are compressed.
+// Encrypted files get the encrypt.Ext extension appended to their names.
+func WithEncryptionKey(key *encrypt.Key) Opts {
+	return func(b *Backup) {
+		b.encryptionKey = key
+	}
+}
+
+// WithLayout sets the template of the stored file paths, DefaultLayout by default
+func WithLayout(layout *Layout) Opts {
+	return func(b *Backup) {
+		b.layout = layout
+	}
+}
+
+// WithGroup sets the router group, which can be used in the layout
+func WithGroup(group string) Opts {
+	return func(b *Backup) {
+		b.group = group
+	}
+}
+
+// New connects to the router using the provided auth method, such as sshclient.WithPassword or sshclient.WithPrivateKeyFile.
+// Host key verification must be set using WithSSHOpts, otherwise the connection is refused.
+func New(ctx context.Context, host, port, user string, auth sshclient.ClientOpts, log *logger.Logger, opts ...Opts) (*Backup, error) {
+	b := &Backup{
+		log:    log,
+		hostIP: host,
+		layout: defaultLayout,
+	}
+
+	for _, f := range opts {
+		f(b)
+	}
+
+	b.tempName = newTempName(b.runID)
+
+	if b.exportFlags.Compact && b.exportFlags.Verbose {
+		return nil, ErrExportCompactVerbose
+	}
+
+	if _, ok := UnchangedExportModes[b.unchangedExport]; b.unchangedExport != "" && !ok {
+		return nil, fmt.Errorf("unchanged export mode %s not supported", b.unchangedExport)
+	}
+
+	if _, ok := compress.Algorithms[string(b.compression)]; b.compression != "" && !ok {
+		return nil, fmt.Errorf("compression %s not supported", b.compression)
+	}
+
+	if _, ok := BackupEncryptions[b.backupEncryption]; b.backupEncryption != "" && !ok {
+		return nil, fmt.Errorf("backup encryption %s not supported", b.backupEncryption)
+	}
+
+	if err := b.extraArtifacts.validate(); err != nil {
+		return nil, err
+	}
+
+	if b.originals != nil && b.originalsKey == nil {
+		return nil, ErrOriginalsKeyNotSet
+	}
+
 	cl, err := sshclient.NewSSH(
+		ctx,
 		user,
 		host,
 		port,
-		sshclient.WithPassword(pass),
-		sshclient.WithIgnoreHostKey(),
-		sshclient.WithInsecureKeyExchange(),
+		append([]sshclient.ClientOpts{auth, sshclient.WithInsecureKeyExchange()}, b.sshOpts...)...,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("could not create ssh client: %w", err)
 	}
 
-	return &Backup{
-		cl:     cl,
-		log:    log,
-		hostIP: host,
-	}, nil
+	b.cl = cl
+
+	return b, nil
+}
+
+// Artifacts returns the files stored by RunBackup
+func (b *Backup) Artifacts() []report.Artifact {
+	return b.artifacts
 }
 
 func (b *Backup) Close() error {
 	return b.cl.Close()
 }
 
-func (b *Backup) GetRouterIdentity() (string, error) {
+// run runs the command on the router, limited by the command timeout
+func (b *Backup) run(ctx context.Context, cmd string) (string, error) {
+	ctx, cancel := withTimeout(ctx, b.commandTimeout)
+	defer cancel()
+
+	return b.cl.Run(ctx, cmd)
+}
+
+// download downloads the file from the router to w, limited by the transfer timeout
+func (b *Backup) download(ctx context.Context, from string, w io.Writer) error {
+	ctx, cancel := withTimeout(ctx, b.transferTimeout)
+	defer cancel()
+
+	return b.cl.Download(ctx, from, w)
+}
+
+// deleteFile removes the router file, limited by the command timeout
+func (b *Backup) deleteFile(ctx context.Context, file string) error {
+	ctx, cancel := withTimeout(ctx, b.commandTimeout)
+	defer cancel()
+
+	return b.cl.Delete(ctx, file)
+}
+
+// runTo writes the command output to w, limited by the transfer timeout
+func (b *Backup) runTo(ctx context.Context, cmd string, w io.Writer) error {
+	ctx, cancel := withTimeout(ctx, b.transferTimeout)
+	defer cancel()
+
+	return b.cl.RunTo(ctx, cmd, w)
+}
+
+// layoutValues returns the layout variable values for the backup taken at the time
+func (b *Backup) layoutValues(ctx context.Context, t time.Time) LayoutValues {
+	v := LayoutValues{
+		Identity: b.host,
+		IP:       b.hostIP,
+		Group:    b.group,
+		RunID:    b.runID,
+		Time:     t,
+	}
+
+	if b.layout.Has(LayoutSerial) {
+		v.Serial = b.GetSerialNumber(ctx)
+	}
+
+	return v
+}
+
+// storedName returns the artifact name with the compression and encryption extensions
+func (b *Backup) storedName(name string) string {
+	name += b.compression.Ext()
+
+	if b.encryptionKey != nil {
+		name += encrypt.Ext
+	}
+
+	return name
+}
+
+// encrypt encrypts the data if the encryption key is set
+func (b *Backup) encrypt(data []byte) ([]byte, error) {
+	if b.encryptionKey == nil {
+		return data, nil
+	}
+
+	return b.encryptionKey.Encrypt(data)
+}
+
+// put compresses, encrypts and stores the artifact with its SHA-256 checksum next to it, in a file with the ChecksumExt extension.
+// The name must be returned by storedName. It returns the artifact location in the storage.
+func (b *Backup) put(ctx context.Context, name string, data []byte) (string, error) {
+	data, err := b.compression.Compress(data)
+	if err != nil {
+		return "", err
+	}
+
+	if data, err = b.encrypt(data); err != nil {
+		return "", fmt.Errorf("could not encrypt %s: %w", name, err)
+	}
+
+	return putWithChecksum(ctx, b.store, name, data)
+}
+
+// putFile compresses, encrypts and stores the local file like put, without reading the whole file to memory.
+// The file is compressed to another local temp file, and streamed to the storage unless it is encrypted,
+// as encrypted files are sealed as a whole and only the compressed file is read to memory then.
+func (b *Backup) putFile(ctx context.Context, name string, file *os.File) (string, error) {
+	if b.compression != compress.None {
+		compressed, err := createLocalTemp()
+		if err != nil {
+			return "", err
+		}
+
+		defer removeLocalTemp(compressed)
+
+		w, err := b.compression.NewWriter(compressed)
+		if err != nil {
+			return "", err
+		}
+
+		if err = copyFrom(w, file); err != nil {
+			return "", fmt.Errorf("could not compress %s: %w", name, err)
+		}
+
+		if err = w.Close(); err != nil {
+			return "", fmt.Errorf("could not compress %s: %w", name, err)
+		}
+
+		file = compressed
+	}
+
+	if b.encryptionKey != nil {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return "", fmt.Errorf("could not read %s: %w", name, err)
+		}
+
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return "", fmt.Errorf("could not read %s: %w", name, err)
+		}
+
+		if data, err = b.encrypt(data); err != nil {
+			return "", fmt.Errorf("could not encrypt %s: %w", name, err)
+		}
+
+		return putWithChecksum(ctx, b.store, name, data)
+	}
+
+	hash := sha256.New()
+
+	if err := copyFrom(hash, file); err != nil {
+		return "", fmt.Errorf("could not read %s: %w", name, err)
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("could not read %s: %w", name, err)
+	}
+
+	if err := b.store.Put(ctx, name, file); err != nil {
+		return "", fmt.Errorf("could not store %s: %w", name, err)
+	}
+
+	if err := putChecksum(ctx, b.store, name, hash.Sum(nil)); err != nil {
+		return "", err
+	}
+
+	return b.store.Location(name), nil
+}
+
+// createLocalTemp creates a local temp file, for artifacts too large to be kept in memory
+func createLocalTemp() (*os.File, error) {
+	file, err := os.CreateTemp("", TempFilePrefix+"*")
+	if err != nil {
+		return nil, fmt.Errorf("could not create local temp file: %w", err)
+	}
+
+	return file, nil
+}
+
+// removeLocalTemp closes and removes the local temp file
+func removeLocalTemp(file *os.File) {
+	_ = file.Close()
+	_ = os.Remove(file.Name())
+}
+
+// resetLocalTemp truncates the local temp file, so it can be reused
+func resetLocalTemp(file *os.File) error {
+	if err := file.Truncate(0); err != nil {
+		return fmt.Errorf("could not reset local temp file: %w", err)
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("could not reset local temp file: %w", err)
+	}
+
+	return nil
+}
+
+// copyFrom copies the whole file to w, from its beginning
+func copyFrom(w io.Writer, file *os.File) error {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	_, err := io.Copy(w, file)
+
+	return err
+}
+
+// putWithChecksum stores the data with its checksum file and returns its location in the storage
+func putWithChecksum(ctx context.Context, store storage.Storage, name string, data []byte) (string, error) {
+	if err := store.Put(ctx, name, bytes.NewReader(data)); err != nil {
+		return "", fmt.Errorf("could not store %s: %w", name, err)
+	}
+
+	sum := sha256.Sum256(data)
+
+	if err := putChecksum(ctx, store, name, sum[:]); err != nil {
+		return "", err
+	}
+
+	return store.Location(name), nil
+}
+
+// putChecksum stores the sha256sum compatible checksum file of the stored file
+func putChecksum(ctx context.Context, store storage.Storage, name string, sum []byte) error {
+	checksum := fmt.Sprintf("%x  %s\n", sum, path.Base(name))
+
+	if err := store.Put(ctx, name+ChecksumExt, strings.NewReader(checksum)); err != nil {
+		return fmt.Errorf("could not store checksum of %s: %w", name, err)
+	}
+
+	return nil
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// GetRouterIdentity returns the router system identity, sanitized for use in file names.
+// If the identity has no usable characters, the router ip address is used instead.
+func (b *Backup) GetRouterIdentity(ctx context.Context) (string, error) {
 	var (
-		host  string
 		ident string
 		err   error
 	)
 	b.log.Debug("Fetching system identity")
 
 	timeout := time.After(time.Minute)
-identLoop:
+
 	for {
+		ident, err = b.run(ctx, ":put [/system identity get name]")
+		if err != nil {
+			return "", fmt.Errorf("could not get system identity: %w", err)
+		}
+
+		ident = strings.TrimSpace(ident)
+		if ident != "" {
+			break
+		}
+
+		b.log.Debug("System identity empty - retrying", "host", b.hostIP)
+
 		select {
 		case <-timeout:
 			return "", fmt.Errorf("empty system identity for %s", b.hostIP)
-		default:
-			ident, err = b.cl.Run("/system identity print")
-			if err != nil {
-				return "", fmt.Errorf("could not get system identity: %w", err)
-			}
-
-			if ident == "" {
-				b.log.Debug("System identity empty - retrying", "host", b.hostIP)
-				time.Sleep(time.Second)
-				continue
-			}
-
-			break identLoop
+		case <-ctx.Done():
+			return "", fmt.Errorf("could not get system identity: %w", ctx.Err())
+		case <-time.After(time.Second):
 		}
 	}
 
-	if len(ident) > 9 {
-		host = strings.TrimSpace(strings.ReplaceAll(ident[8:], " ", "-"))
-	} else {
-		host = strings.TrimSpace(strings.ReplaceAll(ident, " ", "-"))
+	host := SanitizeName(ident)
+	if host == "" {
+		b.log.Warn("System identity can not be used as a file name - using ip address", "identity", ident, "host", b.hostIP)
+		host = SanitizeName(b.hostIP)
 	}
 
-	host = strings.ReplaceAll(host, ":", "")
-
 	b.host = host
 
 	return host, nil
 }
 
-func (b *Backup) RunBackup(bckDir string) error {
-	b.backupDir = bckDir
+// SetName overrides the router name used for the backup files, which defaults to the router identity
+func (b *Backup) SetName(name string) {
+	b.host = name
+}
+
+// RunBackup fetches the backup files from the router and stores them in the storage.
+// The downloaded files are verified, and the backup is retried if the verification fails.
+func (b *Backup) RunBackup(ctx context.Context, store storage.Storage) error {
+	b.store = store
 
 	b.log.Info("Running backup", "host", b.host)
 
-	b.log.Debug("Exporting file on the router", "cmd", "/export file=ssh-backup")
+	name := b.layout.Name(b.layoutValues(ctx, time.Now()))
 
-	_, err := b.cl.Run(fmt.Sprintf("/export file=ssh-backup"))
-	if err != nil {
-		return fmt.Errorf("could not run export: %w", err)
+	var (
+		lastName    string
+		lastContent []byte
+	)
+
+	// the export is committed to the git repository instead of being stored, when the git repository is set
+	if b.gitRepo == nil {
+		// the last export is read once before the new one is stored, as it can be overwritten,
+		// and a retried backup must not compare with the export stored by the failed attempt
+		lastName, lastContent = b.lastExport(ctx)
+	}
+
+	return b.retryVerification(ctx, func() error {
+		b.artifacts = nil
+
+		return b.runBackup(ctx, name, lastName, lastContent)
+	})
+}
+
+// runBackup checks the router free space, and fetches, verifies and stores the backup files,
+// using name as the base of the stored file names. The new export is compared with the last stored one,
+// lastName and lastContent.
+func (b *Backup) runBackup(ctx context.Context, name, lastName string, lastContent []byte) error {
+	if err := b.checkFreeSpace(ctx); err != nil {
+		return err
+	}
+
+	rscName := b.storedName(name + ".rsc")
+	backupName := b.storedName(name + ".backup")
+
+	var export bytes.Buffer
+
+	if b.streamExport {
+		exportCmd, err := b.exportCmd(ctx, "")
+		if err != nil {
+			return err
+		}
+
+		b.log.Debug("Streaming export from the router", "cmd", exportCmd, "host", b.host)
+
+		if err = b.runTo(ctx, exportCmd, &export); err != nil {
+			return fmt.Errorf("could not stream export: %w", err)
+		}
+	} else {
+		exportCmd, err := b.exportCmd(ctx, b.tempName)
+		if err != nil {
+			return err
+		}
+
+		b.log.Debug("Exporting file on the router", "cmd", exportCmd)
+
+		if _, err = b.run(ctx, exportCmd); err != nil {
+			return fmt.Errorf("could not run export: %w", err)
+		}
 	}
 
-	b.log.Debug("Creating system backup on the router", "cmd", "/system backup save name=ssh-backup")
-	_, err = b.cl.Run("/system backup save name=ssh-backup")
+	b.log.Debug("Creating system backup on the router", "name", b.tempName, "encrypted", b.backupPassword != "")
+	backupCmd, err := b.backupSaveCmd(ctx, b.tempName)
 	if err != nil {
+		return err
+	}
+
+	if _, err = b.run(ctx, backupCmd); err != nil {
 		return fmt.Errorf("could not run system backup: %w", err)
 	}
 
 	b.log.Info("Downloading backup files", "host", b.host)
 
-	timeNow := time.Now().Format(time.DateOnly)
+	if !b.streamExport {
+		b.log.Debug("Downloading file", "name", b.remoteFile(".rsc"), "host", b.host)
+
+		if err = b.download(ctx, b.remoteFile(".rsc"), &export); err != nil {
+			return fmt.Errorf("could not download %s: %w", b.remoteFile(".rsc"), err)
+		}
+	}
+
+	if err = verifyExport(name+".rsc", export.Bytes()); err != nil {
+		return err
+	}
+
+	content, err := b.redactExport(ctx, "export", name+".rsc", export.Bytes())
+	if err != nil {
+		return err
+	}
+
+	var exportArtifact report.Artifact
 
-	b.log.Debug("Downloading file", "name", "/ssh-backup.rsc", "host", b.host)
+	if b.gitRepo != nil {
+		exportArtifact, err = b.commitExport(ctx, content)
+	} else {
+		exportArtifact, err = b.handleUnchangedExport(ctx, rscName, content, lastName, lastContent)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// the size of the export written to the router storage, before it was redacted
+	exportArtifact.Size = int64(export.Len())
+
+	b.artifacts = append(b.artifacts, exportArtifact)
+
+	b.log.Debug("Downloading file", "name", b.remoteFile(".backup"), "host", b.host)
+
+	// the binary backup is downloaded to a local temp file, as it can be large
+	backupFile, err := createLocalTemp()
+	if err != nil {
+		return err
+	}
+
+	defer removeLocalTemp(backupFile)
+
+	if err = b.download(ctx, b.remoteFile(".backup"), backupFile); err != nil {
+		return fmt.Errorf("could not download %s: %w", b.remoteFile(".backup"), err)
+	}
+
+	backupInfo, err := backupFile.Stat()
+	if err != nil {
+		return fmt.Errorf("could not stat %s: %w", backupFile.Name(), err)
+	}
+
+	if err = verifyBackupFile(name+".backup", backupFile, backupInfo.Size()); err != nil {
+		return err
+	}
 
-	if err = b.cl.Download(
-		"/ssh-backup.rsc",
-		path.Join(bckDir, fmt.Sprintf("%s-%s.rsc", b.host, timeNow)),
-	); err != nil {
-		return fmt.Errorf("could not download ssh-bakup.rsc: %w", err)
+	backupLocation, err := b.putFile(ctx, backupName, backupFile)
+	if err != nil {
+		return err
 	}
 
-	b.log.Debug("Downloading file", "name", "/ssh-backup.backup", "host", b.host)
+	b.artifacts = append(b.artifacts, report.Artifact{
+		Name:      "backup",
+		Path:      backupLocation,
+		Encrypted: b.backupPassword != "" || b.encryptionKey != nil,
+		Size:      backupInfo.Size(),
+	})
 
-	if err = b.cl.Download(
-		"/ssh-backup.backup",
-		path.Join(bckDir, fmt.Sprintf("%s-%s.backup", b.host, timeNow)),
-	); err != nil {
-		return fmt.Errorf("could not download ssh-backup.backup: %w", err)
+	if err = b.backupExtraArtifacts(ctx, name); err != nil {
+		return err
 	}
 
 	b.log.Info("Backup files downloaded", "host", b.host)
 
+	manifest, err := b.GetManifest(ctx)
+	if err != nil {
+		b.log.Error("Could not collect router metadata", "err", err.Error(), "host", b.host)
+	}
+
+	// manifests are encrypted, but not compressed
+	manifestName := name + ".json"
+	if b.encryptionKey != nil {
+		manifestName += encrypt.Ext
+	}
+
+	manifest.Artifacts = b.artifacts
+
+	manifestLocation, err := b.writeManifest(ctx, manifest, manifestName)
+	if err != nil {
+		return err
+	}
+
+	b.artifacts = append(b.artifacts, report.Artifact{
+		Name:      "manifest",
+		Path:      manifestLocation,
+		Encrypted: b.encryptionKey != nil,
+	})
+
 	b.log.Info("Backup complete", "host", b.host)
 
 	return nil
 }
 
-func (b *Backup) DeleteTempFiles() error {
-	b.log.Info("Deleting temp backup files", "host", b.host)
+// backupSaveCmd returns the command which saves the binary backup, encrypted if the backup password is set.
+// The encryption parameter is left out before RouterOS 6.43, which supports only rc4 and does not accept it.
+func (b *Backup) backupSaveCmd(ctx context.Context, name string) (string, error) {
+	cmd := fmt.Sprintf("/system backup save name=%s", name)
 
-	if err := b.cl.Delete("/ssh-backup.rsc"); err != nil {
-		b.log.Error(
-			"Backup file on the router could not be deleted",
-			"err", err.Error(),
-			"file_name", "/ssh-backup.rsc",
-			"host", b.host,
-		)
+	if b.backupPassword == "" {
+		return cmd, nil
 	}
 
-	if err := b.cl.Delete("/ssh-backup.backup"); err != nil {
-		b.log.Error(
-			"Backup file on the router could not be deleted",
-			"err", err.Error(),
-			"file_name", "/ssh-backup.backup",
-			"host", b.host,
-		)
+	cmd = fmt.Sprintf("%s password=%s", cmd, quote(b.backupPassword))
+
+	if b.backupEncryption == "" {
+		return cmd, nil
 	}
 
-	b.log.Info("Temp backup files deleted", "host", b.host)
+	version, err := b.GetRouterOSVersion(ctx)
+	if err != nil {
+		return "", err
+	}
 
-	return nil
+	if version.Before(6, 43) {
+		if b.backupEncryption != "rc4" {
+			b.log.Warn(
+				"Backup encryption not supported before RouterOS 6.43 - using rc4",
+				"encryption", b.backupEncryption,
+				"version", version.String(),
+				"host", b.host,
+			)
+		}
+
+		return cmd, nil
+	}
+
+	return fmt.Sprintf("%s encryption=%s", cmd, b.backupEncryption), nil
 }
 
-func RunFileCleanup(backupDir string, retentionDays int, log *logger.Logger) error {
-	log.Info("Removing old backup files...")
+// quote returns the value as a RouterOS string literal
+func quote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`).Replace(value) + `"`
+}
 
-	//TODO: in case something goes wrong with the backup, we don't want to delete a backup file no matter how old it is.
-	//currently this logic will delete all backups even if new backups are not being done
-	if err := filepath.WalkDir(backupDir, func(path string, d fs.DirEntry, err error) error {
-		if d != nil && d.IsDir() {
-			return nil
-		}
+func (b *Backup) DeleteTempFiles(ctx context.Context) error {
+	b.log.Info("Deleting temp backup files", "host", b.host)
 
-		fi, err := os.Stat(path)
-		if err != nil {
-			return err
-		}
+	files := []string{b.remoteFile(".backup")}
+	if !b.streamExport {
+		files = append([]string{b.remoteFile(".rsc")}, files...)
+	}
 
-		now := time.Now()
-		if now.Sub(fi.ModTime()).Hours() > float64(retentionDays*24) {
-			log.Info("Deleting old backup file", "name", path)
-			return os.Remove(path)
+	for _, file := range files {
+		// the files are not created if the backup failed before they were saved
+		if err := b.deleteFile(ctx, file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			b.log.Error(
+				"Backup file on the router could not be deleted",
+				"err", err.Error(),
+				"file_name", file,
+				"host", b.host,
+			)
 		}
-
-		return nil
-	}); err != nil {
-		return err
 	}
 
-	log.Info("Backup files cleanup complete")
+	b.log.Info("Temp backup files deleted", "host", b.host)
 
 	return nil
 }
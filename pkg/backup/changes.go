@@ -0,0 +1,168 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ZeljkoBenovic/gombak/pkg/compress"
+	"github.com/ZeljkoBenovic/gombak/pkg/encrypt"
+	"github.com/ZeljkoBenovic/gombak/pkg/report"
+	"github.com/ZeljkoBenovic/gombak/pkg/storage"
+)
+
+// Unchanged export modes define what is done with a new export which is the same as the last stored one
+const (
+	// UnchangedStore stores the new export anyway
+	UnchangedStore = "store"
+	// UnchangedSkip does not store the new export and keeps the last stored one
+	UnchangedSkip = "skip"
+	// UnchangedTouch does not store the new export and updates the modification time of the last stored one,
+	// so it is not removed by the retention policy
+	UnchangedTouch = "touch"
+)
+
+var UnchangedExportModes = map[string]struct{}{
+	UnchangedStore: {},
+	UnchangedSkip:  {},
+	UnchangedTouch: {},
+}
+
+// exportHeader matches the timestamp line RouterOS writes at the top of each export
+var exportHeader = regexp.MustCompile(`(?m)^# .* by RouterOS .*\r?\n`)
+
+// WithUnchangedExport sets what is done with an export that did not change since the last backup, using one of UnchangedExportModes
+func WithUnchangedExport(mode string) Opts {
+	return func(b *Backup) {
+		b.unchangedExport = mode
+	}
+}
+
+// lastExport returns the name and decompressed content of the last export stored for the router, or an empty name if there is none.
+// Exports stored with any compression are taken into account, and encrypted ones if the encryption key is set.
+func (b *Backup) lastExport(ctx context.Context) (string, []byte) {
+	last, ok := b.lastStored(ctx, "rsc")
+	if !ok {
+		return "", nil
+	}
+
+	content, err := b.readStored(ctx, last.Name)
+	if err != nil {
+		b.log.Warn("Could not read last export", "err", err.Error(), "file_name", b.store.Location(last.Name), "host", b.host)
+		return "", nil
+	}
+
+	return last.Name, content
+}
+
+// lastStored returns the last file of the artifact kind stored for the router, and false if there is none
+func (b *Backup) lastStored(ctx context.Context, kind string) (storage.Object, bool) {
+	values := b.layoutValues(ctx, time.Time{})
+	pattern := b.layout.pattern([]string{kind}, &values)
+
+	objects, err := b.store.List(ctx, b.layout.prefix(values))
+	if err != nil {
+		b.log.Warn("Could not list stored backup files", "err", err.Error(), "host", b.host)
+		return storage.Object{}, false
+	}
+
+	var (
+		last     storage.Object
+		lastTime time.Time
+	)
+
+	for _, obj := range objects {
+		parsed, ok := parseStoredName(pattern, obj.Name)
+		if !ok || strings.HasSuffix(obj.Name, ChecksumExt) {
+			continue
+		}
+
+		t := parsed.time
+		if !parsed.hasTime {
+			t = obj.ModTime
+		}
+
+		if last.Name == "" || t.After(lastTime) || (t.Equal(lastTime) && obj.Name > last.Name) {
+			last, lastTime = obj, t
+		}
+	}
+
+	return last, last.Name != ""
+}
+
+// readStored returns the decrypted and decompressed content of the stored file
+func (b *Backup) readStored(ctx context.Context, name string) ([]byte, error) {
+	content, err := storage.ReadAll(ctx, b.store, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasSuffix(name, encrypt.Ext) {
+		if b.encryptionKey == nil {
+			return nil, fmt.Errorf("%s is encrypted but the encryption key is not set", name)
+		}
+
+		if content, err = b.encryptionKey.Decrypt(content); err != nil {
+			return nil, err
+		}
+
+		name = strings.TrimSuffix(name, encrypt.Ext)
+	}
+
+	return compress.Decompress(name, content)
+}
+
+// exportChanged compares the exports, ignoring the timestamp header and line endings
+func exportChanged(previous, current []byte) bool {
+	normalize := func(export []byte) []byte {
+		export = bytes.ReplaceAll(export, []byte("\r\n"), []byte("\n"))
+		return exportHeader.ReplaceAll(export, nil)
+	}
+
+	return !bytes.Equal(normalize(previous), normalize(current))
+}
+
+// handleUnchangedExport compares the new export with the last stored one, applies the unchanged export mode
+// and stores the new export if needed.
+// It returns the export artifact, which points to the last stored export if the new one was not stored.
+func (b *Backup) handleUnchangedExport(ctx context.Context, rscName string, current []byte, lastName string, lastContent []byte) (report.Artifact, error) {
+	artifact := report.Artifact{
+		Name: "export",
+	}
+
+	if lastName != "" {
+		changed := exportChanged(lastContent, current)
+		artifact.Changed = &changed
+
+		b.log.Info("Export compared with the last backup", "changed", changed, "last", b.store.Location(lastName), "host", b.host)
+
+		// the last export is overwritten by the new one if both are from the same day
+		if !changed && lastName != rscName && (b.unchangedExport == UnchangedSkip || b.unchangedExport == UnchangedTouch) {
+			artifact.Path = b.store.Location(lastName)
+			artifact.Encrypted = strings.HasSuffix(lastName, encrypt.Ext)
+
+			if b.unchangedExport == UnchangedTouch {
+				if err := b.store.Touch(ctx, lastName); err != nil {
+					return artifact, fmt.Errorf("could not touch last export: %w", err)
+				}
+
+				_ = b.store.Touch(ctx, lastName+ChecksumExt)
+			}
+
+			return artifact, nil
+		}
+	}
+
+	location, err := b.put(ctx, rscName, current)
+	if err != nil {
+		return artifact, err
+	}
+
+	artifact.Path = location
+	artifact.Encrypted = b.encryptionKey != nil
+
+	return artifact, nil
+}
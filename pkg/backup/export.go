@@ -0,0 +1,134 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrExportCompactVerbose = errors.New("compact and verbose export flags can not be used together")
+
+// ExportFlags are the /export command parameters.
+// They are mapped to the parameters supported by the RouterOS version of the router.
+type ExportFlags struct {
+	// ShowSensitive shows or hides sensitive values, like passwords and keys.
+	// If nil, the RouterOS default is used, which is to show them on v6 and hide them on v7.
+	ShowSensitive *bool
+	Compact       bool
+	Verbose       bool
+	Terse         bool
+}
+
+// WithExportFlags sets the /export command parameters
+func WithExportFlags(flags ExportFlags) Opts {
+	return func(b *Backup) {
+		b.exportFlags = flags
+	}
+}
+
+// RouterOSVersion is the major and minor RouterOS version
+type RouterOSVersion struct {
+	Major int
+	Minor int
+}
+
+// Before reports whether the version is older than major.minor
+func (v RouterOSVersion) Before(major, minor int) bool {
+	return v.Major < major || (v.Major == major && v.Minor < minor)
+}
+
+func (v RouterOSVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// ParseRouterOSVersion parses the major and minor version from the RouterOS version string, such as 6.48.6 (long-term) or 7.1beta4
+func ParseRouterOSVersion(version string) (RouterOSVersion, error) {
+	version = strings.TrimSpace(version)
+	major, rest, _ := strings.Cut(version, ".")
+
+	v := RouterOSVersion{}
+
+	var err error
+
+	if v.Major, err = strconv.Atoi(major); err != nil {
+		return RouterOSVersion{}, fmt.Errorf("could not parse routeros version %q: %w", version, err)
+	}
+
+	// the minor version can be followed by the patch version, a pre-release suffix or the release channel
+	minor := rest
+	if end := strings.IndexFunc(rest, func(r rune) bool { return r < '0' || r > '9' }); end >= 0 {
+		minor = rest[:end]
+	}
+
+	if minor != "" {
+		if v.Minor, err = strconv.Atoi(minor); err != nil {
+			return RouterOSVersion{}, fmt.Errorf("could not parse routeros version %q: %w", version, err)
+		}
+	}
+
+	return v, nil
+}
+
+// GetRouterOSVersion returns the major and minor RouterOS version of the router
+func (b *Backup) GetRouterOSVersion(ctx context.Context) (RouterOSVersion, error) {
+	if b.osVersion.Major != 0 {
+		return b.osVersion, nil
+	}
+
+	out, err := b.run(ctx, ":put [/system resource get version]")
+	if err != nil {
+		return RouterOSVersion{}, fmt.Errorf("could not get routeros version: %w", err)
+	}
+
+	version, err := ParseRouterOSVersion(out)
+	if err != nil {
+		return RouterOSVersion{}, err
+	}
+
+	b.osVersion = version
+
+	return version, nil
+}
+
+// exportCmd returns the /export command with the parameters mapped to the router version
+func (b *Backup) exportCmd(ctx context.Context, file string) (string, error) {
+	args := []string{"/export"}
+
+	if file != "" {
+		args = append(args, "file="+file)
+	}
+
+	f := b.exportFlags
+
+	if f.ShowSensitive != nil || f.Compact {
+		version, err := b.GetRouterOSVersion(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		switch {
+		case f.ShowSensitive == nil:
+		case version.Major >= 7 && *f.ShowSensitive:
+			args = append(args, "show-sensitive")
+		case version.Major < 7 && !*f.ShowSensitive:
+			args = append(args, "hide-sensitive")
+		}
+
+		// compact is the default on v7 and is not accepted as a parameter
+		if f.Compact && version.Major < 7 {
+			args = append(args, "compact")
+		}
+	}
+
+	if f.Verbose {
+		args = append(args, "verbose")
+	}
+
+	if f.Terse {
+		args = append(args, "terse")
+	}
+
+	return strings.Join(args, " "), nil
+}
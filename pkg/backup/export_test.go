@@ -0,0 +1,103 @@
+package backup
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseRouterOSVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    RouterOSVersion
+		wantErr bool
+	}{
+		{version: "6.48.6 (long-term)", want: RouterOSVersion{Major: 6, Minor: 48}},
+		{version: "7.12.1 (stable)\r\n", want: RouterOSVersion{Major: 7, Minor: 12}},
+		{version: "7.1beta4", want: RouterOSVersion{Major: 7, Minor: 1}},
+		{version: "7.15rc2 (testing)", want: RouterOSVersion{Major: 7, Minor: 15}},
+		{version: "7", want: RouterOSVersion{Major: 7}},
+		{version: "", wantErr: true},
+		{version: "v7.12", wantErr: true},
+		{version: "bad command name version", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			got, err := ParseRouterOSVersion(tt.version)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRouterOSVersion(%q) error = %v, want error %v", tt.version, err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("ParseRouterOSVersion(%q) = %v, want %v", tt.version, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouterOSVersionBefore(t *testing.T) {
+	v := RouterOSVersion{Major: 7, Minor: 12}
+
+	tests := []struct {
+		major, minor int
+		want         bool
+	}{
+		{major: 6, minor: 49},
+		{major: 7, minor: 12},
+		{major: 7, minor: 13, want: true},
+		{major: 8, minor: 0, want: true},
+	}
+
+	for _, tt := range tests {
+		if got := v.Before(tt.major, tt.minor); got != tt.want {
+			t.Errorf("%s.Before(%d, %d) = %v, want %v", v, tt.major, tt.minor, got, tt.want)
+		}
+	}
+}
+
+func TestExportCmd(t *testing.T) {
+	show, hide := true, false
+
+	v6 := RouterOSVersion{Major: 6, Minor: 49}
+	v7 := RouterOSVersion{Major: 7, Minor: 12}
+
+	tests := []struct {
+		name    string
+		version RouterOSVersion
+		file    string
+		flags   ExportFlags
+		want    string
+	}{
+		{name: "defaults", version: v7, want: "/export"},
+		{name: "file", version: v7, file: "gombak-tmp", want: "/export file=gombak-tmp"},
+		{name: "show sensitive on v7", version: v7, flags: ExportFlags{ShowSensitive: &show}, want: "/export show-sensitive"},
+		{name: "show sensitive on v6 is the default", version: v6, flags: ExportFlags{ShowSensitive: &show}, want: "/export"},
+		{name: "hide sensitive on v6", version: v6, flags: ExportFlags{ShowSensitive: &hide}, want: "/export hide-sensitive"},
+		{name: "hide sensitive on v7 is the default", version: v7, flags: ExportFlags{ShowSensitive: &hide}, want: "/export"},
+		{name: "compact on v6", version: v6, flags: ExportFlags{Compact: true}, want: "/export compact"},
+		{name: "compact on v7 is the default", version: v7, flags: ExportFlags{Compact: true}, want: "/export"},
+		{
+			name:    "all flags on v6",
+			version: v6,
+			file:    "gombak-tmp",
+			flags:   ExportFlags{ShowSensitive: &hide, Verbose: true, Terse: true},
+			want:    "/export file=gombak-tmp hide-sensitive verbose terse",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the cached version is used, so the router is not queried
+			b := &Backup{exportFlags: tt.flags, osVersion: tt.version}
+
+			got, err := b.exportCmd(context.Background(), tt.file)
+			if err != nil {
+				t.Fatalf("exportCmd() error: %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("exportCmd() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/ZeljkoBenovic/gombak/pkg/gitstore"
+	"github.com/ZeljkoBenovic/gombak/pkg/report"
+)
+
+// GitRoutersDir is the git repository directory where router exports are committed
+const GitRoutersDir = "routers"
+
+// WithGitRepo commits the export to the git repository, at a stable path, instead of storing it in the backup dir
+func WithGitRepo(repo *gitstore.Repo) Opts {
+	return func(b *Backup) {
+		b.gitRepo = repo
+	}
+}
+
+// commitExport commits the export to the git repository as routers/<identity>.rsc
+func (b *Backup) commitExport(ctx context.Context, content []byte) (report.Artifact, error) {
+	file := path.Join(GitRoutersDir, b.host+".rsc")
+
+	artifact := report.Artifact{
+		Name: "export",
+		Path: filepath.Join(b.gitRepo.Dir(), file),
+	}
+
+	// the timestamp header changes on every export, so only the real changes are committed
+	committed, err := os.ReadFile(artifact.Path)
+	if err == nil && !exportChanged(committed, content) {
+		changed := false
+		artifact.Changed = &changed
+
+		b.log.Info("Export not changed since the last commit", "host", b.host)
+
+		return artifact, nil
+	}
+
+	hash, err := b.gitRepo.Commit(ctx, file, content, fmt.Sprintf(
+		"Backup %s at %s\n\nHost: %s\nRun: %s",
+		b.host, time.Now().Format(time.RFC3339), b.hostIP, b.runID,
+	))
+	if err != nil {
+		return artifact, fmt.Errorf("could not commit export: %w", err)
+	}
+
+	changed := hash != ""
+	artifact.Changed = &changed
+	artifact.Commit = hash
+
+	b.log.Info("Export committed to git repository", "changed", changed, "commit", hash, "host", b.host)
+
+	return artifact, nil
+}
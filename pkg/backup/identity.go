@@ -0,0 +1,58 @@
+package backup
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+var (
+	unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+	serialNumber    = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+)
+
+// SanitizeName replaces the characters which are not safe in file names with a dash
+func SanitizeName(name string) string {
+	return strings.Trim(unsafeNameChars.ReplaceAllString(name, "-"), "-.")
+}
+
+// serialScripts return the unique router id, in order of preference.
+// Routerboard serial number is not available on CHR and x86 installations, where the license software or system id is used.
+var serialScripts = []string{
+	":put [/system routerboard get serial-number]",
+	":put [/system license get software-id]",
+	":put [/system license get system-id]",
+}
+
+// GetSerialNumber returns the router serial number, or an empty string if it can not be determined
+func (b *Backup) GetSerialNumber(ctx context.Context) string {
+	if b.serial != "" {
+		return b.serial
+	}
+
+	for _, script := range serialScripts {
+		out, err := b.run(ctx, script)
+		if err != nil {
+			b.log.Debug("Could not get router serial number", "err", err.Error(), "host", b.hostIP)
+			continue
+		}
+
+		// RouterOS prints the error messages to the output
+		if out = strings.TrimSpace(out); serialNumber.MatchString(out) {
+			b.serial = out
+
+			return out
+		}
+	}
+
+	return ""
+}
+
+// RouterID returns the unique router id: the serial number, or the ip address if the serial number can not be determined
+func (b *Backup) RouterID(ctx context.Context) string {
+	if serial := b.GetSerialNumber(ctx); serial != "" {
+		return serial
+	}
+
+	return b.hostIP
+}
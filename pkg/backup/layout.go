@@ -0,0 +1,298 @@
+package backup
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Layout variables which can be used in the layout template
+const (
+	LayoutIdentity = "identity"
+	LayoutIP       = "ip"
+	LayoutSerial   = "serial"
+	LayoutGroup    = "group"
+	LayoutDate     = "date"
+	LayoutTime     = "time"
+	LayoutRunID    = "run_id"
+)
+
+// DefaultLayout stores the backup files in the storage root, named after the router and the date
+const DefaultLayout = "{identity}-{date}"
+
+// DefaultGroup is used for the routers which are not assigned to a group
+const DefaultGroup = "default"
+
+// layoutPatterns match the variable values in the stored file names
+var layoutPatterns = map[string]string{
+	LayoutIdentity: `[^/]+?`,
+	LayoutIP:       `[^/]+?`,
+	LayoutSerial:   `[^/]+?`,
+	LayoutGroup:    `[^/]+?`,
+	LayoutDate:     `\d{4}-\d{2}-\d{2}`,
+	LayoutTime:     `\d{6}`,
+	LayoutRunID:    `\d{8}T\d{6}-[0-9a-f]+`,
+}
+
+// routerVariables identify the router the file belongs to, in order of preference
+var routerVariables = []string{LayoutIdentity, LayoutIP, LayoutSerial}
+
+// artifactKinds match the extensions of the stored backup files, which are followed by the compression,
+// encryption and checksum extensions. Each script and certificate is a separate kind, so they are retained separately.
+var artifactKinds = []string{"rsc", "backup", "json", `users\.rsc`, `script-[^/]+\.rsc`, `cert-[^/]+\.p12`, `files\.tar`}
+
+var layoutVariable = regexp.MustCompile(`\{([a-z_]*)\}`)
+
+var defaultLayout = mustParseLayout(DefaultLayout)
+
+// Layout is the template of the stored backup file paths, without the file extension.
+// Variables are written in braces, for example {identity}/{date}-{time}.
+type Layout struct {
+	template string
+	// parts alternate between the literal text and the variable names
+	parts []layoutPart
+}
+
+type layoutPart struct {
+	literal  string
+	variable string
+}
+
+// LayoutValues are the values of the layout variables for a single backup
+type LayoutValues struct {
+	Identity string
+	IP       string
+	Serial   string
+	Group    string
+	RunID    string
+	Time     time.Time
+}
+
+// ParseLayout parses the layout template. The template must contain at least one of the identity, ip or serial variables,
+// so that the files of different routers do not overwrite each other.
+func ParseLayout(template string) (*Layout, error) {
+	if template == "" {
+		template = DefaultLayout
+	}
+
+	if strings.HasPrefix(template, "/") {
+		return nil, fmt.Errorf("layout %s must be relative to the storage root", template)
+	}
+
+	for _, elem := range strings.Split(template, "/") {
+		if elem == "" || elem == "." || elem == ".." {
+			return nil, fmt.Errorf("layout %s contains an invalid path element", template)
+		}
+	}
+
+	l := &Layout{
+		template: template,
+	}
+
+	last := 0
+
+	for _, m := range layoutVariable.FindAllStringSubmatchIndex(template, -1) {
+		variable := template[m[2]:m[3]]
+		if _, ok := layoutPatterns[variable]; !ok {
+			return nil, fmt.Errorf("layout variable {%s} not supported", variable)
+		}
+
+		l.parts = append(l.parts, layoutPart{literal: template[last:m[0]]}, layoutPart{variable: variable})
+		last = m[1]
+	}
+
+	l.parts = append(l.parts, layoutPart{literal: template[last:]})
+
+	for _, p := range l.parts {
+		if strings.ContainsAny(p.literal, "{}") {
+			return nil, fmt.Errorf("layout %s contains an unmatched brace", template)
+		}
+	}
+
+	if !l.Has(LayoutIdentity) && !l.Has(LayoutIP) && !l.Has(LayoutSerial) {
+		return nil, fmt.Errorf("layout %s must contain {identity}, {ip} or {serial}", template)
+	}
+
+	return l, nil
+}
+
+func mustParseLayout(template string) *Layout {
+	l, err := ParseLayout(template)
+	if err != nil {
+		panic(err)
+	}
+
+	return l
+}
+
+// Has reports whether the layout uses the variable
+func (l *Layout) Has(variable string) bool {
+	for _, p := range l.parts {
+		if p.variable == variable {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (l *Layout) String() string {
+	return l.template
+}
+
+// Name returns the file path for the values, without the file extension
+func (l *Layout) Name(v LayoutValues) string {
+	var sb strings.Builder
+
+	for _, p := range l.parts {
+		if p.variable == "" {
+			sb.WriteString(p.literal)
+			continue
+		}
+
+		sb.WriteString(l.value(p.variable, v))
+	}
+
+	return sb.String()
+}
+
+func (l *Layout) value(variable string, v LayoutValues) string {
+	switch variable {
+	case LayoutIdentity:
+		return v.Identity
+	case LayoutIP:
+		return SanitizeName(v.IP)
+	case LayoutSerial:
+		if v.Serial == "" {
+			return SanitizeName(v.IP)
+		}
+
+		return SanitizeName(v.Serial)
+	case LayoutGroup:
+		if v.Group == "" {
+			return DefaultGroup
+		}
+
+		return SanitizeName(v.Group)
+	case LayoutDate:
+		return v.Time.Format(time.DateOnly)
+	case LayoutTime:
+		return v.Time.Format("150405")
+	case LayoutRunID:
+		return v.RunID
+	default:
+		return ""
+	}
+}
+
+// isTimeVariable reports whether the variable value changes on each backup of the same router
+func isTimeVariable(variable string) bool {
+	return variable == LayoutDate || variable == LayoutTime || variable == LayoutRunID
+}
+
+// pattern returns the regular expression matching the stored files of the artifact kinds.
+// If fixed is set, the router variables are matched literally, and only the time variables can vary.
+func (l *Layout) pattern(kinds []string, fixed *LayoutValues) *regexp.Regexp {
+	var (
+		sb   strings.Builder
+		seen = make(map[string]bool)
+	)
+
+	sb.WriteString("^")
+
+	for _, p := range l.parts {
+		switch {
+		case p.variable == "":
+			sb.WriteString(regexp.QuoteMeta(p.literal))
+		case fixed != nil && !isTimeVariable(p.variable):
+			sb.WriteString(regexp.QuoteMeta(l.value(p.variable, *fixed)))
+		case seen[p.variable]:
+			// the same variable can be used more than once, but group names must be unique
+			sb.WriteString("(?:" + layoutPatterns[p.variable] + ")")
+		default:
+			sb.WriteString("(?P<" + p.variable + ">" + layoutPatterns[p.variable] + ")")
+			seen[p.variable] = true
+		}
+	}
+
+	sb.WriteString(`\.(?P<kind>` + strings.Join(kinds, "|") + `)(\.gz|\.zst)?(\.enc)?(\.sha256)?$`)
+
+	return regexp.MustCompile(sb.String())
+}
+
+// prefix returns the fixed beginning of the stored file names, used to limit the storage listing
+func (l *Layout) prefix(fixed LayoutValues) string {
+	var sb strings.Builder
+
+	for _, p := range l.parts {
+		switch {
+		case p.variable == "":
+			sb.WriteString(p.literal)
+		case isTimeVariable(p.variable):
+			return sb.String()
+		default:
+			sb.WriteString(l.value(p.variable, fixed))
+		}
+	}
+
+	return sb.String()
+}
+
+// storedName is a stored file name parsed using the layout
+type storedName struct {
+	// base is the file path without the extensions
+	base string
+	kind string
+	// routers are the router variable values found in the name, in order of preference
+	routers []string
+	time    time.Time
+	// hasTime is false if the layout has no date or run id, in which case the file modification time is used
+	hasTime bool
+}
+
+// parseStoredName parses the file name matched by the pattern
+func parseStoredName(re *regexp.Regexp, name string) (storedName, bool) {
+	m := re.FindStringSubmatchIndex(name)
+	if m == nil {
+		return storedName{}, false
+	}
+
+	values := make(map[string]string)
+
+	for i, group := range re.SubexpNames() {
+		if group != "" && m[2*i] >= 0 {
+			values[group] = name[m[2*i]:m[2*i+1]]
+		}
+	}
+
+	kind := re.SubexpIndex("kind")
+
+	parsed := storedName{
+		base: name[:m[2*kind]-1],
+		kind: values["kind"],
+	}
+
+	for _, variable := range routerVariables {
+		if v, ok := values[variable]; ok {
+			parsed.routers = append(parsed.routers, v)
+		}
+	}
+
+	var err error
+
+	switch {
+	case values[LayoutRunID] != "":
+		parsed.time, err = time.ParseInLocation("20060102T150405", values[LayoutRunID][:15], time.Local)
+	case values[LayoutDate] != "" && values[LayoutTime] != "":
+		parsed.time, err = time.ParseInLocation(time.DateOnly+"150405", values[LayoutDate]+values[LayoutTime], time.Local)
+	case values[LayoutDate] != "":
+		parsed.time, err = time.ParseInLocation(time.DateOnly, values[LayoutDate], time.Local)
+	default:
+		return parsed, true
+	}
+
+	parsed.hasTime = err == nil
+
+	return parsed, true
+}
@@ -0,0 +1,176 @@
+package backup
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseLayout(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{name: "default", template: "", want: DefaultLayout},
+		{name: "directories", template: "{group}/{identity}/{date}-{time}", want: "{group}/{identity}/{date}-{time}"},
+		{name: "run id", template: "{serial}/{run_id}", want: "{serial}/{run_id}"},
+		{name: "variable used twice", template: "{identity}/{identity}-{date}", want: "{identity}/{identity}-{date}"},
+		{name: "absolute", template: "/backups/{identity}", wantErr: true},
+		{name: "parent dir", template: "{identity}/../{date}", wantErr: true},
+		{name: "empty path element", template: "{identity}//{date}", wantErr: true},
+		{name: "unsupported variable", template: "{identity}-{hostname}", wantErr: true},
+		{name: "unmatched brace", template: "{identity}-{date", wantErr: true},
+		{name: "no router variable", template: "{group}/{date}", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := ParseLayout(tt.template)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseLayout(%q) = %q, want error", tt.template, l)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ParseLayout(%q) error: %v", tt.template, err)
+			}
+
+			if l.String() != tt.want {
+				t.Errorf("ParseLayout(%q) = %q, want %q", tt.template, l, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseStoredName(t *testing.T) {
+	date := func(year int, month time.Month, day, hour, min, sec int) time.Time {
+		return time.Date(year, month, day, hour, min, sec, 0, time.Local)
+	}
+
+	tests := []struct {
+		name       string
+		layout     string
+		file       string
+		want       storedName
+		wantParsed bool
+	}{
+		{
+			name:       "export",
+			layout:     DefaultLayout,
+			file:       "router1-2024-01-31.rsc",
+			want:       storedName{base: "router1-2024-01-31", kind: "rsc", routers: []string{"router1"}, time: date(2024, 1, 31, 0, 0, 0), hasTime: true},
+			wantParsed: true,
+		},
+		{
+			name:       "identity with dashes and digits",
+			layout:     DefaultLayout,
+			file:       "core-2024-01-01-2024-01-31.backup",
+			want:       storedName{base: "core-2024-01-01-2024-01-31", kind: "backup", routers: []string{"core-2024-01-01"}, time: date(2024, 1, 31, 0, 0, 0), hasTime: true},
+			wantParsed: true,
+		},
+		{
+			name:       "compressed encrypted checksum",
+			layout:     DefaultLayout,
+			file:       "router1-2024-01-31.backup.gz.enc.sha256",
+			want:       storedName{base: "router1-2024-01-31", kind: "backup", routers: []string{"router1"}, time: date(2024, 1, 31, 0, 0, 0), hasTime: true},
+			wantParsed: true,
+		},
+		{
+			name:       "users export",
+			layout:     DefaultLayout,
+			file:       "router1-2024-01-31.users.rsc.zst",
+			want:       storedName{base: "router1-2024-01-31", kind: "users.rsc", routers: []string{"router1"}, time: date(2024, 1, 31, 0, 0, 0), hasTime: true},
+			wantParsed: true,
+		},
+		{
+			name:       "script export",
+			layout:     DefaultLayout,
+			file:       "router1-2024-01-31.script-daily-backup.rsc.gz",
+			want:       storedName{base: "router1-2024-01-31", kind: "script-daily-backup.rsc", routers: []string{"router1"}, time: date(2024, 1, 31, 0, 0, 0), hasTime: true},
+			wantParsed: true,
+		},
+		{
+			name:       "certificate",
+			layout:     DefaultLayout,
+			file:       "router1-2024-01-31.cert-ca.p12.enc",
+			want:       storedName{base: "router1-2024-01-31", kind: "cert-ca.p12", routers: []string{"router1"}, time: date(2024, 1, 31, 0, 0, 0), hasTime: true},
+			wantParsed: true,
+		},
+		{
+			name:       "files archive",
+			layout:     DefaultLayout,
+			file:       "router1-2024-01-31.files.tar.zst.enc.sha256",
+			want:       storedName{base: "router1-2024-01-31", kind: "files.tar", routers: []string{"router1"}, time: date(2024, 1, 31, 0, 0, 0), hasTime: true},
+			wantParsed: true,
+		},
+		{
+			name:       "run id",
+			layout:     "{identity}/{run_id}",
+			file:       "router1/20240131T101500-1a2b3c.json.enc",
+			want:       storedName{base: "router1/20240131T101500-1a2b3c", kind: "json", routers: []string{"router1"}, time: date(2024, 1, 31, 10, 15, 0), hasTime: true},
+			wantParsed: true,
+		},
+		{
+			name:       "all router variables with date and time",
+			layout:     "{group}/{serial}/{ip}/{identity}-{date}-{time}",
+			file:       "core/HB1234/10-0-0-1/router1-2024-01-31-101500.rsc",
+			want:       storedName{base: "core/HB1234/10-0-0-1/router1-2024-01-31-101500", kind: "rsc", routers: []string{"router1", "10-0-0-1", "HB1234"}, time: date(2024, 1, 31, 10, 15, 0), hasTime: true},
+			wantParsed: true,
+		},
+		{
+			name:       "no time variable",
+			layout:     "{identity}",
+			file:       "router1.backup.gz",
+			want:       storedName{base: "router1", kind: "backup", routers: []string{"router1"}},
+			wantParsed: true,
+		},
+		{
+			name:   "unknown kind",
+			layout: DefaultLayout,
+			file:   "router1-2024-01-31.txt",
+		},
+		{
+			name:   "extensions out of order",
+			layout: DefaultLayout,
+			file:   "router1-2024-01-31.rsc.enc.gz",
+		},
+		{
+			name:   "date instead of run id",
+			layout: "{identity}/{run_id}",
+			file:   "router1/2024-01-31.json",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := ParseLayout(tt.layout)
+			if err != nil {
+				t.Fatalf("ParseLayout(%q) error: %v", tt.layout, err)
+			}
+
+			got, parsed := parseStoredName(l.pattern(artifactKinds, nil), tt.file)
+			if parsed != tt.wantParsed {
+				t.Fatalf("parseStoredName(%q) parsed = %v, want %v", tt.file, parsed, tt.wantParsed)
+			}
+
+			if !parsed {
+				return
+			}
+
+			if !got.time.Equal(tt.want.time) {
+				t.Errorf("parseStoredName(%q) time = %v, want %v", tt.file, got.time, tt.want.time)
+			}
+
+			got.time, tt.want.time = time.Time{}, time.Time{}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseStoredName(%q) = %+v, want %+v", tt.file, got, tt.want)
+			}
+		})
+	}
+}
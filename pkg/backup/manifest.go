@@ -0,0 +1,177 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ZeljkoBenovic/gombak/pkg/report"
+	"github.com/ZeljkoBenovic/gombak/pkg/storage"
+)
+
+// Manifest holds the router metadata stored alongside each backup
+type Manifest struct {
+	Identity string    `json:"identity"`
+	Host     string    `json:"host"`
+	ID       string    `json:"id,omitempty"`
+	RunID    string    `json:"run_id,omitempty"`
+	Created  time.Time `json:"created"`
+
+	Version      string `json:"version"`
+	Architecture string `json:"architecture"`
+	BoardName    string `json:"board_name"`
+	Uptime       string `json:"uptime"`
+
+	Routerboard     bool   `json:"routerboard"`
+	Model           string `json:"model,omitempty"`
+	SerialNumber    string `json:"serial_number,omitempty"`
+	FirmwareVersion string `json:"firmware_version,omitempty"`
+
+	Packages  []Package         `json:"packages"`
+	Artifacts []report.Artifact `json:"artifacts"`
+}
+
+// Package is a RouterOS package installed on the router
+type Package struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+const (
+	resourceScript = `:put ("version=" . [/system resource get version]);` +
+		`:put ("architecture=" . [/system resource get architecture-name]);` +
+		`:put ("board-name=" . [/system resource get board-name]);` +
+		`:put ("uptime=" . [/system resource get uptime])`
+	routerboardScript = `:put ("routerboard=" . [/system routerboard get routerboard]);` +
+		`:put ("model=" . [/system routerboard get model]);` +
+		`:put ("serial-number=" . [/system routerboard get serial-number]);` +
+		`:put ("current-firmware=" . [/system routerboard get current-firmware])`
+	packageScript = `:foreach p in=[/system package find] do={` +
+		`:put ([/system package get $p name] . "=" . [/system package get $p version])}`
+)
+
+// GetManifest collects the router metadata from /system resource, /system routerboard and /system package
+func (b *Backup) GetManifest(ctx context.Context) (Manifest, error) {
+	m := Manifest{
+		Identity: b.host,
+		Host:     b.hostIP,
+		ID:       b.RouterID(ctx),
+		RunID:    b.runID,
+		Created:  time.Now(),
+	}
+
+	b.log.Debug("Fetching router metadata", "host", b.host)
+
+	out, err := b.run(ctx, resourceScript)
+	if err != nil {
+		return m, fmt.Errorf("could not get system resource: %w", err)
+	}
+
+	res := parseKeyValues(out)
+	m.Version = res["version"]
+	m.Architecture = res["architecture"]
+	m.BoardName = res["board-name"]
+	m.Uptime = res["uptime"]
+
+	// routerboard info is not available on CHR and x86 installations
+	out, err = b.run(ctx, routerboardScript)
+	if err != nil {
+		b.log.Debug("Could not get routerboard info", "err", err.Error(), "host", b.host)
+	} else {
+		rb := parseKeyValues(out)
+		m.Routerboard = rb["routerboard"] == "true"
+		m.Model = rb["model"]
+		m.SerialNumber = rb["serial-number"]
+		m.FirmwareVersion = rb["current-firmware"]
+	}
+
+	out, err = b.run(ctx, packageScript)
+	if err != nil {
+		return m, fmt.Errorf("could not get installed packages: %w", err)
+	}
+
+	for _, line := range strings.Split(out, "\n") {
+		name, version, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+
+		m.Packages = append(m.Packages, Package{Name: name, Version: version})
+	}
+
+	return m, nil
+}
+
+// writeManifest stores the manifest as a json file, with its checksum file next to it.
+// It returns the manifest location in the storage.
+func (b *Backup) writeManifest(ctx context.Context, m Manifest, name string) (string, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("could not marshal manifest: %w", err)
+	}
+
+	if data, err = b.encrypt(data); err != nil {
+		return "", fmt.Errorf("could not encrypt manifest: %w", err)
+	}
+
+	return putWithChecksum(ctx, b.store, name, data)
+}
+
+// StoredID returns the unique id of the router which backed up to the current router name last,
+// found in its last stored manifest, or an empty string if it is not known
+func (b *Backup) StoredID(ctx context.Context, store storage.Storage) string {
+	b.store = store
+
+	m, ok := b.lastManifest(ctx)
+	if !ok {
+		return ""
+	}
+
+	// manifests stored by older versions have only the routerboard serial number
+	if m.ID == "" {
+		return m.SerialNumber
+	}
+
+	return m.ID
+}
+
+// lastManifest returns the last manifest stored for the router, if there is one and it can be read
+func (b *Backup) lastManifest(ctx context.Context) (Manifest, bool) {
+	var m Manifest
+
+	last, ok := b.lastStored(ctx, "json")
+	if !ok {
+		return m, false
+	}
+
+	data, err := b.readStored(ctx, last.Name)
+	if err != nil {
+		b.log.Warn("Could not read last manifest", "err", err.Error(), "file_name", b.store.Location(last.Name), "host", b.host)
+		return m, false
+	}
+
+	if err = json.Unmarshal(data, &m); err != nil {
+		b.log.Warn("Could not parse last manifest", "err", err.Error(), "file_name", b.store.Location(last.Name), "host", b.host)
+		return m, false
+	}
+
+	return m, true
+}
+
+// parseKeyValues parses the key=value lines printed by the router scripts
+func parseKeyValues(out string) map[string]string {
+	values := make(map[string]string)
+
+	for _, line := range strings.Split(out, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+
+		values[key] = value
+	}
+
+	return values
+}
@@ -0,0 +1,387 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ZeljkoBenovic/gombak/pkg/encrypt"
+	"github.com/ZeljkoBenovic/gombak/pkg/report"
+	"github.com/ZeljkoBenovic/gombak/pkg/storage"
+)
+
+// RedactedValue replaces the redacted parameter values in the export
+const RedactedValue = `"*redacted*"`
+
+// redactedEscaped replaces the redacted parameter values inside the quoted strings of the export, such as script sources
+const redactedEscaped = `\"*redacted*\"`
+
+var ErrOriginalsKeyNotSet = errors.New("redacted originals store requires an encryption key")
+
+// RedactRule redacts the values of the parameters in the export
+type RedactRule struct {
+	// Menu limits the rule to the export section, such as /snmp community. If empty, the rule applies to all sections.
+	Menu string
+	// Params are the names of the parameters which values are redacted
+	Params []string
+}
+
+// DefaultRedactRules redact the passwords, keys and secrets found in RouterOS exports
+var DefaultRedactRules = []RedactRule{
+	{
+		Params: []string{
+			"password",
+			"passphrase",
+			"secret",
+			"pre-shared-key",
+			"preshared-key",
+			"wpa-pre-shared-key",
+			"wpa2-pre-shared-key",
+			"private-key",
+			"auth-key",
+			"tcp-md5-key",
+			"authentication-password",
+			"encryption-password",
+		},
+	},
+	{
+		Menu:   "/snmp community",
+		Params: []string{"name"},
+	},
+}
+
+var redactParamName = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// exportCommands end the menu path on the terse export lines
+var exportCommands = map[string]struct{}{
+	"add":     {},
+	"set":     {},
+	"remove":  {},
+	"enable":  {},
+	"disable": {},
+}
+
+// Redactor rewrites the sensitive parameter values in the exports
+type Redactor struct {
+	rules []redactRule
+}
+
+type redactRule struct {
+	menu   string
+	params map[string]struct{}
+}
+
+// NewRedactor returns the redactor using the rules, or DefaultRedactRules if there are none
+func NewRedactor(rules []RedactRule) (*Redactor, error) {
+	if len(rules) == 0 {
+		rules = DefaultRedactRules
+	}
+
+	r := &Redactor{}
+
+	for _, rule := range rules {
+		if len(rule.Params) == 0 {
+			return nil, fmt.Errorf("redact rule for menu %q has no params", rule.Menu)
+		}
+
+		for _, p := range rule.Params {
+			if !redactParamName.MatchString(p) {
+				return nil, fmt.Errorf("redact param %q is not a valid parameter name", p)
+			}
+		}
+
+		params := make(map[string]struct{}, len(rule.Params))
+		for _, p := range rule.Params {
+			params[p] = struct{}{}
+		}
+
+		r.rules = append(r.rules, redactRule{
+			menu:   strings.Join(strings.Fields(rule.Menu), " "),
+			params: params,
+		})
+	}
+
+	return r, nil
+}
+
+// Redact returns the export with the values of the rule parameters replaced by RedactedValue.
+// The lines wrapped with a trailing backslash are joined into one command before they are redacted,
+// and a redacted command is written on a single line.
+func (r *Redactor) Redact(export []byte) []byte {
+	var (
+		out   bytes.Buffer
+		menu  string
+		lines []string
+	)
+
+	for _, line := range strings.SplitAfter(string(export), "\n") {
+		lines = append(lines, line)
+
+		if continued(line) {
+			continue
+		}
+
+		command, ending := joinLines(lines)
+
+		switch trimmed := strings.TrimSpace(command); {
+		case strings.HasPrefix(trimmed, "#"):
+		case strings.HasPrefix(trimmed, "/"):
+			menu = exportMenu(trimmed)
+			fallthrough
+		default:
+			if redacted := r.redact(menu, command); redacted != command {
+				out.WriteString(redacted + ending)
+				lines = nil
+
+				continue
+			}
+		}
+
+		// commands without secrets keep their original wrapping
+		out.WriteString(strings.Join(lines, ""))
+		lines = nil
+	}
+
+	out.WriteString(strings.Join(lines, ""))
+
+	return out.Bytes()
+}
+
+// redact replaces the values of the rule parameters in the command, using the rules for the menu.
+// The parameters are also redacted inside the quoted strings, such as script sources, with escaped quotes,
+// so the quotes of the command stay balanced.
+func (r *Redactor) redact(menu, command string) string {
+	params := make(map[string]struct{})
+
+	for _, rule := range r.rules {
+		if rule.menu == "" || rule.menu == menu {
+			for p := range rule.params {
+				params[p] = struct{}{}
+			}
+		}
+	}
+
+	var (
+		out      strings.Builder
+		inString bool
+	)
+
+	for i := 0; i < len(command); {
+		switch c := command[i]; {
+		case inString && c == '\\' && i+1 < len(command):
+			out.WriteString(command[i : i+2])
+			i += 2
+
+			continue
+		case c == '"':
+			inString = !inString
+			out.WriteByte(c)
+			i++
+
+			continue
+		}
+
+		if param, ok := paramAt(command, i, params); ok {
+			start := i + len(param) + 1
+
+			if end := valueEnd(command, start, inString); end > start {
+				out.WriteString(param + "=")
+
+				if inString {
+					out.WriteString(redactedEscaped)
+				} else {
+					out.WriteString(RedactedValue)
+				}
+
+				i = end
+
+				continue
+			}
+		}
+
+		out.WriteByte(command[i])
+		i++
+	}
+
+	return out.String()
+}
+
+// paramAt returns the parameter name which starts at i and is followed by =, if it is one of the params
+func paramAt(command string, i int, params map[string]struct{}) (string, bool) {
+	// the parameter follows a white space, a bracket or a semicolon, or an escaped new line or tab inside a string
+	if i > 0 && !strings.ContainsRune(" \t[{(;", rune(command[i-1])) &&
+		!(i > 1 && command[i-2] == '\\' && strings.ContainsRune("nrt", rune(command[i-1]))) {
+		return "", false
+	}
+
+	end := i
+	for end < len(command) && (command[end] == '-' || 'a' <= command[end] && command[end] <= 'z' || '0' <= command[end] && command[end] <= '9') {
+		end++
+	}
+
+	if end == i || end == len(command) || command[end] != '=' {
+		return "", false
+	}
+
+	if _, ok := params[command[i:end]]; !ok {
+		return "", false
+	}
+
+	return command[i:end], true
+}
+
+// valueEnd returns the end of the parameter value starting at i. Values are quoted, with escaped quotes inside,
+// or run until the next white space. Inside a quoted string the values are quoted with escaped quotes,
+// or run until the next white space, escape sequence or the end of the string.
+func valueEnd(command string, i int, inString bool) int {
+	switch {
+	case !inString && strings.HasPrefix(command[i:], `"`):
+		for j := i + 1; j < len(command); j++ {
+			switch command[j] {
+			case '\\':
+				j++
+			case '"':
+				return j + 1
+			}
+		}
+
+		return len(command)
+	case inString && strings.HasPrefix(command[i:], `\"`):
+		for j := i + 2; j < len(command); j++ {
+			switch {
+			case command[j] == '"':
+				// the string ends before the value is closed
+				return j
+			case strings.HasPrefix(command[j:], `\\\"`):
+				// the quote escaped inside the value
+				j += 3
+			case strings.HasPrefix(command[j:], `\"`):
+				return j + 2
+			case command[j] == '\\':
+				j++
+			}
+		}
+
+		return len(command)
+	}
+
+	stop := " \t]"
+	if inString {
+		stop += `"\;`
+	}
+
+	j := i
+	for j < len(command) && !strings.ContainsRune(stop, rune(command[j])) {
+		j++
+	}
+
+	return j
+}
+
+// IsRedacted reports whether the export contains redacted values
+func IsRedacted(export []byte) bool {
+	return bytes.Contains(export, []byte(RedactedValue)) || bytes.Contains(export, []byte(redactedEscaped))
+}
+
+// continued reports whether the export line is wrapped, ending with a backslash which is not escaped
+func continued(line string) bool {
+	trimmed := strings.TrimRight(line, "\r\n")
+
+	return (len(trimmed)-len(strings.TrimRight(trimmed, `\`)))%2 == 1
+}
+
+// joinLines joins the wrapped export lines into one command, dropping the trailing backslashes
+// and the indentation of the continuation lines. It returns the command and the line ending of the last line.
+func joinLines(lines []string) (string, string) {
+	var sb strings.Builder
+
+	last := lines[len(lines)-1]
+	ending := last[len(strings.TrimRight(last, "\r\n")):]
+
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r\n")
+
+		if i > 0 {
+			line = strings.TrimLeft(line, " \t")
+		}
+
+		if i < len(lines)-1 {
+			line = strings.TrimSuffix(line, `\`)
+		}
+
+		sb.WriteString(line)
+	}
+
+	return sb.String(), ending
+}
+
+// exportMenu returns the menu path the export line starts with, such as /snmp community
+func exportMenu(line string) string {
+	var menu []string
+
+	for _, word := range strings.Fields(line) {
+		if _, ok := exportCommands[word]; ok || strings.ContainsAny(word, `=["\`) {
+			break
+		}
+
+		menu = append(menu, word)
+	}
+
+	return strings.Join(menu, " ")
+}
+
+// WithRedactor redacts the exports before they are stored or committed.
+// If originals is set, the unredacted exports are stored there, encrypted with the key.
+func WithRedactor(r *Redactor, originals storage.Storage, key *encrypt.Key) Opts {
+	return func(b *Backup) {
+		b.redactor = r
+		b.originals = originals
+		b.originalsKey = key
+	}
+}
+
+// redactExport returns the export redacted, if the redactor is set. The unredacted export is stored
+// in the originals store first, if it is set. The artifact names the export in the run report.
+func (b *Backup) redactExport(ctx context.Context, artifact, name string, export []byte) ([]byte, error) {
+	if b.redactor == nil {
+		return export, nil
+	}
+
+	if b.originals != nil {
+		if err := b.putOriginal(ctx, artifact, name, export); err != nil {
+			return nil, err
+		}
+	}
+
+	return b.redactor.Redact(export), nil
+}
+
+// putOriginal stores the unredacted export in the originals store, compressed and encrypted with the originals key
+func (b *Backup) putOriginal(ctx context.Context, artifact, name string, export []byte) error {
+	name += b.compression.Ext() + encrypt.Ext
+
+	data, err := b.compression.Compress(export)
+	if err != nil {
+		return err
+	}
+
+	if data, err = b.originalsKey.Encrypt(data); err != nil {
+		return fmt.Errorf("could not encrypt %s: %w", name, err)
+	}
+
+	location, err := putWithChecksum(ctx, b.originals, name, data)
+	if err != nil {
+		return err
+	}
+
+	b.artifacts = append(b.artifacts, report.Artifact{
+		Name:      artifact + "-original",
+		Path:      location,
+		Encrypted: true,
+	})
+
+	return nil
+}
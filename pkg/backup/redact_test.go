@@ -0,0 +1,144 @@
+package backup
+
+import (
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name   string
+		export string
+		want   string
+	}{
+		{
+			name:   "unquoted value",
+			export: "/ppp secret\nadd name=user1 password=s3cret profile=default\n",
+			want:   "/ppp secret\nadd name=user1 password=\"*redacted*\" profile=default\n",
+		},
+		{
+			name:   "quoted value with escaped quotes and spaces",
+			export: "/ppp secret\nadd name=user1 password=\"a \\\"b\\\" c\" profile=default\n",
+			want:   "/ppp secret\nadd name=user1 password=\"*redacted*\" profile=default\n",
+		},
+		{
+			name:   "menu on the command line",
+			export: "/user add group=full name=admin2 password=s3cret\n",
+			want:   "/user add group=full name=admin2 password=\"*redacted*\"\n",
+		},
+		{
+			name:   "value on the continuation line",
+			export: "/interface wireguard\nadd listen-port=13231 mtu=1420 name=wg0 private-key=\\\n    \"aGVsbG8gd29ybGQ=\"\n",
+			want:   "/interface wireguard\nadd listen-port=13231 mtu=1420 name=wg0 private-key=\"*redacted*\"\n",
+		},
+		{
+			name:   "value wrapped inside the quotes",
+			export: "/ppp secret\nadd name=user1 password=\"abc\\\n    def\" profile=default\n",
+			want:   "/ppp secret\nadd name=user1 password=\"*redacted*\" profile=default\n",
+		},
+		{
+			name:   "wrapped command without secrets keeps its wrapping",
+			export: "/ip address\nadd address=192.168.88.1/24 comment=defconf \\\n    interface=bridge network=192.168.88.0\n",
+			want:   "/ip address\nadd address=192.168.88.1/24 comment=defconf \\\n    interface=bridge network=192.168.88.0\n",
+		},
+		{
+			name:   "escaped backslash at the end of a line",
+			export: "/system script\nadd name=a source=\"c:\\\\\"\nadd name=b password=x\n",
+			want:   "/system script\nadd name=a source=\"c:\\\\\"\nadd name=b password=\"*redacted*\"\n",
+		},
+		{
+			name:   "unquoted value inside a script source",
+			export: "/system script\nadd name=fetch source=\"/tool fetch url=http://10.0.0.1/a user=admin password=secret\" comment=c\n",
+			want:   "/system script\nadd name=fetch source=\"/tool fetch url=http://10.0.0.1/a user=admin password=\\\"*redacted*\\\"\" comment=c\n",
+		},
+		{
+			name:   "escaped quoted value inside a script source",
+			export: "/system script\nadd name=mail source=\":log info start\\r\\n/tool e-mail set password=\\\"s3 cr\\\\\\\"et\\\" user=me\" policy=read\n",
+			want:   "/system script\nadd name=mail source=\":log info start\\r\\n/tool e-mail set password=\\\"*redacted*\\\" user=me\" policy=read\n",
+		},
+		{
+			name:   "value at the end of a script source",
+			export: "/system script\nadd name=s source=\"/ppp secret set [find] password=abc\"\n",
+			want:   "/system script\nadd name=s source=\"/ppp secret set [find] password=\\\"*redacted*\\\"\"\n",
+		},
+		{
+			name:   "snmp community name",
+			export: "/snmp community\nset [ find default=yes ] name=public\nadd addresses=10.0.0.0/8 name=private\n",
+			want:   "/snmp community\nset [ find default=yes ] name=\"*redacted*\"\nadd addresses=10.0.0.0/8 name=\"*redacted*\"\n",
+		},
+		{
+			name:   "name outside of snmp community",
+			export: "/interface bridge\nadd name=bridge\n",
+			want:   "/interface bridge\nadd name=bridge\n",
+		},
+		{
+			name:   "parameter name suffix",
+			export: "/tool netwatch\nadd comment=user-password=x host=10.0.0.1\n",
+			want:   "/tool netwatch\nadd comment=user-password=x host=10.0.0.1\n",
+		},
+		{
+			name:   "comment line",
+			export: "# password=not-a-secret\n/ip dns\nset servers=1.1.1.1\n",
+			want:   "# password=not-a-secret\n/ip dns\nset servers=1.1.1.1\n",
+		},
+		{
+			name:   "windows line endings",
+			export: "/ppp secret\r\nadd name=user1 password=\\\r\n    s3cret\r\n",
+			want:   "/ppp secret\r\nadd name=user1 password=\"*redacted*\"\r\n",
+		},
+	}
+
+	r, err := NewRedactor(nil)
+	if err != nil {
+		t.Fatalf("NewRedactor() error: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(r.Redact([]byte(tt.export)))
+			if got != tt.want {
+				t.Errorf("Redact() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewRedactor(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []RedactRule
+		wantErr bool
+	}{
+		{name: "default rules"},
+		{name: "custom rule", rules: []RedactRule{{Menu: "/ip  ipsec  identity", Params: []string{"secret"}}}},
+		{name: "no params", rules: []RedactRule{{Menu: "/snmp community"}}, wantErr: true},
+		{name: "invalid param", rules: []RedactRule{{Params: []string{"pass word"}}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewRedactor(tt.rules); (err != nil) != tt.wantErr {
+				t.Errorf("NewRedactor() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIsRedacted(t *testing.T) {
+	tests := []struct {
+		name   string
+		export string
+		want   bool
+	}{
+		{name: "plain", export: "/ppp secret\nadd name=user1 password=s3cret\n"},
+		{name: "redacted", export: "/ppp secret\nadd name=user1 password=\"*redacted*\"\n", want: true},
+		{name: "redacted in a string", export: "/system script\nadd source=\"/ppp secret set 0 password=\\\"*redacted*\\\"\"\n", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRedacted([]byte(tt.export)); got != tt.want {
+				t.Errorf("IsRedacted() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	sshclient "github.com/ZeljkoBenovic/gombak/pkg/ssh"
+)
+
+var (
+	ErrRestoreFileType = errors.New("only .backup and .rsc files can be restored")
+	ErrRedactedExport  = errors.New("export is redacted, restore the unredacted copy from redact.originals-dir " +
+		"or set redact.originals-key-file to load it automatically")
+)
+
+// importSuccess is printed by RouterOS when the whole imported file was executed
+const importSuccess = "executed successfully"
+
+// upload uploads the data to the router file, limited by the transfer timeout
+func (b *Backup) upload(ctx context.Context, data []byte, to string) error {
+	ctx, cancel := withTimeout(ctx, b.transferTimeout)
+	defer cancel()
+
+	return b.cl.Upload(ctx, bytes.NewReader(data), to)
+}
+
+// restoreCmd returns the command which loads the uploaded file on the router, using the binary backup password if it is set
+func restoreCmd(ext, file, password string) string {
+	if ext == ".rsc" {
+		return "/import file-name=" + file
+	}
+
+	cmd := "/system backup load name=" + file
+
+	if password != "" {
+		cmd = fmt.Sprintf("%s password=%s", cmd, quote(password))
+	}
+
+	return cmd
+}
+
+// Restore uploads the binary backup or the export to the router, and loads it with /system backup load or /import.
+// The name is the file name without the compression and encryption extensions, and data is the decrypted and decompressed file.
+// Redacted exports are refused. Loading a binary backup reboots the router. If dryRun is set, the file is verified, but it is not uploaded or loaded.
+func (b *Backup) Restore(ctx context.Context, name string, data []byte, dryRun bool) error {
+	ext := path.Ext(name)
+
+	switch ext {
+	case ".backup":
+		if err := verifyBackupFile(name, bytes.NewReader(data), int64(len(data))); err != nil {
+			return err
+		}
+	case ".rsc":
+		if err := verifyExport(name, data); err != nil {
+			return err
+		}
+
+		// importing a redacted export would replace the secrets with the placeholder
+		if IsRedacted(data) {
+			return ErrRedactedExport
+		}
+	default:
+		return ErrRestoreFileType
+	}
+
+	file := b.tempName + ext
+
+	if dryRun {
+		password := b.backupPassword
+		if password != "" {
+			password = "*****"
+		}
+
+		b.log.Info(
+			"Dry run - the file would be uploaded and loaded",
+			"host", b.hostIP,
+			"identity", b.host,
+			"file", name,
+			"size", len(data),
+			"upload_to", "/"+file,
+			"cmd", restoreCmd(ext, file, password),
+		)
+
+		return nil
+	}
+
+	b.log.Info("Uploading file to the router", "file", name, "upload_to", "/"+file, "host", b.hostIP, "identity", b.host)
+
+	if err := b.upload(ctx, data, "/"+file); err != nil {
+		return fmt.Errorf("could not upload %s: %w", name, err)
+	}
+
+	if ext == ".rsc" {
+		defer b.deleteTempFile(ctx, "/"+file)
+	}
+
+	b.log.Info("Loading file on the router", "file", file, "host", b.hostIP, "identity", b.host)
+
+	out, err := b.run(ctx, restoreCmd(ext, file, b.backupPassword))
+
+	switch {
+	case ext == ".backup" && errors.Is(err, sshclient.ErrSessionClosed):
+		b.log.Info("Backup loaded - the router is rebooting", "host", b.hostIP, "identity", b.host)
+		return nil
+	case err != nil:
+		return fmt.Errorf("could not load %s: %w", name, err)
+	case ext == ".rsc" && !strings.Contains(out, importSuccess):
+		return fmt.Errorf("could not import %s: %s", name, strings.TrimSpace(out))
+	}
+
+	b.log.Info("File loaded on the router", "file", name, "host", b.hostIP, "identity", b.host)
+
+	return nil
+}
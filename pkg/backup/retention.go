@@ -0,0 +1,359 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ZeljkoBenovic/gombak/pkg/encrypt"
+	"github.com/ZeljkoBenovic/gombak/pkg/logger"
+	"github.com/ZeljkoBenovic/gombak/pkg/report"
+	"github.com/ZeljkoBenovic/gombak/pkg/storage"
+)
+
+// RetentionPolicy defines which stored files are deleted by RunFileCleanup
+type RetentionPolicy struct {
+	// Days is the age after which the files are deleted, if none of the GFS counts are set.
+	// It is also used for the files which are not router backups, such as run reports.
+	Days int
+
+	// KeepLast, Daily, Weekly, Monthly and Yearly are the grandfather-father-son policy counts.
+	// Each one keeps the newest backup in each of that many most recent periods which have a backup.
+	KeepLast int
+	Daily    int
+	Weekly   int
+	Monthly  int
+	Yearly   int
+
+	// MinKeep is the number of latest backups of each router which are never deleted, whatever their age
+	MinKeep int
+	// FailedRouters defines how the backups of the routers which failed in this run are cleaned up,
+	// using one of FailedRoutersModes
+	FailedRouters string
+}
+
+// Failed routers modes define how the backups of a router which failed in the current run are cleaned up
+const (
+	// FailedRoutersSkip does not delete any backup of the failed router
+	FailedRoutersSkip = "skip"
+	// FailedRoutersWarn logs a warning and applies the retention policy anyway
+	FailedRoutersWarn = "warn"
+)
+
+var FailedRoutersModes = map[string]struct{}{
+	FailedRoutersSkip: {},
+	FailedRoutersWarn: {},
+}
+
+// GFS reports whether any of the grandfather-father-son counts is set
+func (p RetentionPolicy) GFS() bool {
+	return p.KeepLast > 0 || p.Daily > 0 || p.Weekly > 0 || p.Monthly > 0 || p.Yearly > 0
+}
+
+// backupSet holds the files of one router backup artifact kind, such as rsc or backup, stored at the same time
+type backupSet struct {
+	// routers are the router identity, ip address or serial number found in the file names
+	routers []string
+	kind    string
+	time    time.Time
+	objects []storage.Object
+}
+
+// modTime returns the newest modification time of the set files, which is updated when an unchanged export is touched
+func (s *backupSet) modTime() time.Time {
+	var t time.Time
+
+	for _, obj := range s.objects {
+		if obj.ModTime.After(t) {
+			t = obj.ModTime
+		}
+	}
+
+	return t
+}
+
+// catalog groups the stored backup files into sets by router and artifact kind, using the layout to parse the file names.
+// It returns the files which are not router backups, such as run reports, separately.
+func catalog(objects []storage.Object, layout *Layout) (map[string][]*backupSet, []storage.Object) {
+	var (
+		sets   = make(map[string][]*backupSet)
+		byName = make(map[string]*backupSet)
+		other  []storage.Object
+		re     = layout.pattern(artifactKinds, nil)
+	)
+
+	for _, obj := range objects {
+		if strings.HasPrefix(obj.Name, report.Dir+"/") {
+			other = append(other, obj)
+			continue
+		}
+
+		parsed, ok := parseStoredName(re, obj.Name)
+		if !ok {
+			other = append(other, obj)
+			continue
+		}
+
+		setName := parsed.base + "." + parsed.kind
+
+		set, ok := byName[setName]
+		if !ok {
+			set = &backupSet{
+				routers: parsed.routers,
+				kind:    parsed.kind,
+				time:    parsed.time,
+			}
+
+			byName[setName] = set
+
+			routerKind := strings.Join(parsed.routers, "/") + "." + parsed.kind
+			sets[routerKind] = append(sets[routerKind], set)
+		}
+
+		// without the date in the layout, the files are ordered by their modification time
+		if !parsed.hasTime && obj.ModTime.After(set.time) {
+			set.time = obj.ModTime
+		}
+
+		set.objects = append(set.objects, obj)
+	}
+
+	return sets, other
+}
+
+// keep returns the sets kept by the GFS policy. The sets must be sorted from the newest.
+func (p RetentionPolicy) keep(sets []*backupSet) map[*backupSet]struct{} {
+	kept := make(map[*backupSet]struct{})
+
+	for i := 0; i < p.KeepLast && i < len(sets); i++ {
+		kept[sets[i]] = struct{}{}
+	}
+
+	periods := []struct {
+		count  int
+		period func(t time.Time) string
+	}{
+		{p.Daily, func(t time.Time) string { return t.Format(time.DateOnly) }},
+		{p.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-%d", year, week)
+		}},
+		{p.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{p.Yearly, func(t time.Time) string { return t.Format("2006") }},
+	}
+
+	for _, pr := range periods {
+		var last string
+
+		for i, n := 0, 0; i < len(sets) && n < pr.count; i++ {
+			if period := pr.period(sets[i].time); period != last {
+				kept[sets[i]] = struct{}{}
+				last = period
+				n++
+			}
+		}
+	}
+
+	return kept
+}
+
+// RunFileCleanup deletes the stored files according to the retention policy, using the layout to find the backups of each router.
+// Each router artifact kind, such as the export or the binary backup, is evaluated separately,
+// so the last export is kept even if the following exports were skipped as unchanged.
+// The latest MinKeep backups of each router are always kept, and the backups of the failed routers,
+// identified by their identity, ip address or serial number, are handled according to the FailedRouters mode.
+// If layout is nil, DefaultLayout is used.
+func RunFileCleanup(
+	ctx context.Context,
+	store storage.Storage,
+	layout *Layout,
+	policy RetentionPolicy,
+	failed []string,
+	log *logger.Logger,
+) error {
+	if _, ok := FailedRoutersModes[policy.FailedRouters]; policy.FailedRouters != "" && !ok {
+		return fmt.Errorf("failed routers mode %s not supported", policy.FailedRouters)
+	}
+
+	log.Info("Removing old backup files...")
+
+	objects, err := store.List(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	expired := func(t time.Time) bool {
+		return time.Since(t).Hours() > float64(policy.Days*24)
+	}
+
+	failedRouters := make(map[string]struct{}, len(failed))
+	for _, name := range failed {
+		failedRouters[name] = struct{}{}
+	}
+
+	var remove []storage.Object
+
+	if layout == nil {
+		layout = defaultLayout
+	}
+
+	sets, other := catalog(objects, layout)
+
+	for _, obj := range other {
+		if expired(obj.ModTime) {
+			remove = append(remove, obj)
+		}
+	}
+
+	warned := make(map[string]struct{})
+
+	for _, routerSets := range sets {
+		router := strings.Join(routerSets[0].routers, "/")
+
+		if routerFailed(routerSets[0].routers, failedRouters) {
+			if _, ok := warned[router]; !ok {
+				if policy.FailedRouters == FailedRoutersWarn {
+					log.Warn("Router backup failed in this run - cleaning up its backup files anyway", "router", router)
+				} else {
+					log.Warn("Router backup failed in this run - its backup files are not cleaned up", "router", router)
+				}
+
+				warned[router] = struct{}{}
+			}
+
+			if policy.FailedRouters != FailedRoutersWarn {
+				continue
+			}
+		}
+
+		sort.Slice(routerSets, func(i, j int) bool {
+			return routerSets[i].time.After(routerSets[j].time)
+		})
+
+		var kept map[*backupSet]struct{}
+		if policy.GFS() {
+			kept = policy.keep(routerSets)
+		}
+
+		for i, set := range routerSets {
+			// the latest backups are kept no matter how old they are, in case new backups are failing
+			if i < policy.MinKeep {
+				continue
+			}
+
+			if policy.GFS() {
+				if _, ok := kept[set]; ok {
+					continue
+				}
+			} else if !expired(set.modTime()) {
+				continue
+			}
+
+			remove = append(remove, set.objects...)
+		}
+	}
+
+	for _, obj := range remove {
+		log.Info("Deleting old backup file", "name", store.Location(obj.Name))
+
+		if err = store.Delete(ctx, obj.Name); err != nil {
+			return err
+		}
+	}
+
+	log.Info("Backup files cleanup complete")
+
+	return nil
+}
+
+// routerFailed reports whether any of the router identity, ip address or serial number is in the failed routers
+func routerFailed(routers []string, failed map[string]struct{}) bool {
+	for _, r := range routers {
+		if _, ok := failed[r]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LastManifests returns the last stored manifest of each router, by the router host address.
+// It is used to identify the routers which failed before their identity was known. Encrypted manifests are decrypted with the key,
+// and skipped if it is not set.
+func LastManifests(ctx context.Context, store storage.Storage, layout *Layout, key *encrypt.Key) (map[string]Manifest, error) {
+	objects, err := store.List(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if layout == nil {
+		layout = defaultLayout
+	}
+
+	sets, _ := catalog(objects, layout)
+	manifests := make(map[string]Manifest)
+
+	for _, routerSets := range sets {
+		if routerSets[0].kind != "json" {
+			continue
+		}
+
+		sort.Slice(routerSets, func(i, j int) bool {
+			return routerSets[i].time.After(routerSets[j].time)
+		})
+
+		m, ok := readManifest(ctx, store, routerSets[0], key)
+		if !ok {
+			continue
+		}
+
+		if last, ok := manifests[m.Host]; !ok || m.Created.After(last.Created) {
+			manifests[m.Host] = m
+		}
+	}
+
+	return manifests, nil
+}
+
+// readManifest reads the manifest file of the set, skipping its checksum file
+func readManifest(ctx context.Context, store storage.Storage, set *backupSet, key *encrypt.Key) (Manifest, bool) {
+	var m Manifest
+
+	for _, obj := range set.objects {
+		encrypted := strings.HasSuffix(obj.Name, ".json"+encrypt.Ext)
+		if !encrypted && !strings.HasSuffix(obj.Name, ".json") {
+			continue
+		}
+
+		// encrypted manifests can not be read without the key
+		if encrypted && key == nil {
+			return m, false
+		}
+
+		r, err := store.Get(ctx, obj.Name)
+		if err != nil {
+			return m, false
+		}
+
+		data, err := io.ReadAll(r)
+		_ = r.Close()
+
+		if err != nil {
+			return m, false
+		}
+
+		if encrypted {
+			if data, err = key.Decrypt(data); err != nil {
+				return m, false
+			}
+		}
+
+		return m, json.Unmarshal(data, &m) == nil
+	}
+
+	return m, false
+}
@@ -0,0 +1,276 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ZeljkoBenovic/gombak/pkg/config"
+	"github.com/ZeljkoBenovic/gombak/pkg/logger"
+	"github.com/ZeljkoBenovic/gombak/pkg/storage"
+)
+
+func TestRetentionPolicyKeep(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy RetentionPolicy
+		// times are the backup times, from the newest
+		times []string
+		// want are the indexes of the kept backups
+		want []int
+	}{
+		{
+			name:   "keep last",
+			policy: RetentionPolicy{KeepLast: 2},
+			times:  []string{"2024-01-03 10:00", "2024-01-03 08:00", "2024-01-02 10:00"},
+			want:   []int{0, 1},
+		},
+		{
+			name:   "daily keeps the newest backup of each day",
+			policy: RetentionPolicy{Daily: 3},
+			times:  []string{"2024-01-03 10:00", "2024-01-03 08:00", "2024-01-02 23:59", "2024-01-01 00:00", "2023-12-31 23:59"},
+			want:   []int{0, 2, 3},
+		},
+		{
+			name:   "daily skips the days without a backup",
+			policy: RetentionPolicy{Daily: 2},
+			times:  []string{"2024-01-10 10:00", "2024-01-03 10:00", "2024-01-01 10:00"},
+			want:   []int{0, 1},
+		},
+		{
+			name:   "weekly across the week boundary",
+			policy: RetentionPolicy{Weekly: 3},
+			// 2024-01-08 is the Monday of week 2, 2023-12-31 is the Sunday of week 52 of 2023
+			times: []string{"2024-01-08 10:00", "2024-01-07 10:00", "2024-01-01 10:00", "2023-12-31 10:00"},
+			want:  []int{0, 1, 3},
+		},
+		{
+			name:   "weekly across the year boundary",
+			policy: RetentionPolicy{Weekly: 2},
+			// 2024-12-30 is in the first week of 2025
+			times: []string{"2025-01-02 10:00", "2024-12-30 10:00", "2024-12-29 10:00"},
+			want:  []int{0, 2},
+		},
+		{
+			name:   "monthly across the month boundary",
+			policy: RetentionPolicy{Monthly: 2},
+			times:  []string{"2024-03-01 00:00", "2024-02-29 23:59", "2024-02-01 00:00", "2024-01-31 23:59"},
+			want:   []int{0, 1},
+		},
+		{
+			name:   "yearly across the year boundary",
+			policy: RetentionPolicy{Yearly: 3},
+			times:  []string{"2025-01-01 00:00", "2024-12-31 23:59", "2024-01-01 00:00", "2023-12-31 23:59"},
+			want:   []int{0, 1, 3},
+		},
+		{
+			name:   "periods are combined",
+			policy: RetentionPolicy{KeepLast: 1, Daily: 2, Monthly: 2},
+			times:  []string{"2024-02-02 10:00", "2024-02-02 08:00", "2024-02-01 10:00", "2024-01-31 10:00", "2024-01-30 10:00"},
+			want:   []int{0, 2, 3},
+		},
+		{
+			name:   "more periods than backups",
+			policy: RetentionPolicy{Daily: 7},
+			times:  []string{"2024-01-02 10:00", "2024-01-01 10:00"},
+			want:   []int{0, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sets := make([]*backupSet, 0, len(tt.times))
+
+			for _, v := range tt.times {
+				tm, err := time.ParseInLocation("2006-01-02 15:04", v, time.Local)
+				if err != nil {
+					t.Fatalf("could not parse time %q: %v", v, err)
+				}
+
+				sets = append(sets, &backupSet{time: tm})
+			}
+
+			kept := tt.policy.keep(sets)
+
+			var got []int
+
+			for i, set := range sets {
+				if _, ok := kept[set]; ok {
+					got = append(got, i)
+				}
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("keep() kept %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunFileCleanup(t *testing.T) {
+	files := []string{
+		"router1-2024-01-01.rsc",
+		"router1-2024-01-02.rsc",
+		"router1-2024-01-03.rsc",
+		"router1-2024-01-03.rsc.sha256",
+		"router1-2024-01-02.backup.gz.enc",
+		"router2-2024-01-01.rsc",
+		"router2-2024-01-02.rsc",
+		"runs/20240103T100000-1a2b3c.json",
+	}
+
+	tests := []struct {
+		name    string
+		policy  RetentionPolicy
+		failed  []string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:   "gfs per router and kind",
+			policy: RetentionPolicy{Days: 7, Daily: 2},
+			want: []string{
+				"router1-2024-01-02.backup.gz.enc",
+				"router1-2024-01-02.rsc",
+				"router1-2024-01-03.rsc",
+				"router1-2024-01-03.rsc.sha256",
+				"router2-2024-01-01.rsc",
+				"router2-2024-01-02.rsc",
+			},
+		},
+		{
+			name:   "failed router is skipped",
+			policy: RetentionPolicy{Days: 7, Daily: 1},
+			failed: []string{"router2"},
+			want: []string{
+				"router1-2024-01-02.backup.gz.enc",
+				"router1-2024-01-03.rsc",
+				"router1-2024-01-03.rsc.sha256",
+				"router2-2024-01-01.rsc",
+				"router2-2024-01-02.rsc",
+			},
+		},
+		{
+			name:   "failed router is cleaned up with warn",
+			policy: RetentionPolicy{Days: 7, Daily: 1, FailedRouters: FailedRoutersWarn},
+			failed: []string{"router2"},
+			want: []string{
+				"router1-2024-01-02.backup.gz.enc",
+				"router1-2024-01-03.rsc",
+				"router1-2024-01-03.rsc.sha256",
+				"router2-2024-01-02.rsc",
+			},
+		},
+		{
+			name:   "min keep protects expired backups",
+			policy: RetentionPolicy{Days: 7, MinKeep: 1},
+			want: []string{
+				"router1-2024-01-02.backup.gz.enc",
+				"router1-2024-01-03.rsc",
+				"router1-2024-01-03.rsc.sha256",
+				"router2-2024-01-02.rsc",
+			},
+		},
+		{
+			name:   "nothing expired",
+			policy: RetentionPolicy{Days: 365},
+			want:   files,
+		},
+		{
+			name:    "unsupported failed routers mode",
+			policy:  RetentionPolicy{Days: 7, FailedRouters: "ignore"},
+			want:    files,
+			wantErr: true,
+		},
+	}
+
+	log, err := logger.New(config.Config{})
+	if err != nil {
+		t.Fatalf("could not create logger: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			modTime := time.Now().AddDate(0, 0, -30)
+
+			for _, name := range files {
+				file := filepath.Join(dir, filepath.FromSlash(name))
+
+				if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := os.WriteFile(file, []byte(name), 0o644); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := os.Chtimes(file, modTime, modTime); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			store := storage.NewLocalDir(dir)
+
+			err := RunFileCleanup(context.Background(), store, nil, tt.policy, tt.failed, log)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RunFileCleanup() error = %v, want error %v", err, tt.wantErr)
+			}
+
+			objects, err := store.List(context.Background(), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]string, 0, len(objects))
+			for _, obj := range objects {
+				got = append(got, obj.Name)
+			}
+
+			want := append([]string(nil), tt.want...)
+
+			sort.Strings(got)
+			sort.Strings(want)
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("RunFileCleanup() left %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestLastManifests(t *testing.T) {
+	dir := t.TempDir()
+
+	manifests := map[string]string{
+		"router1-2024-01-01.json":        `{"identity":"router1","host":"10.0.0.1","id":"OLD1"}`,
+		"router1-2024-01-02.json":        `{"identity":"router1","host":"10.0.0.1","id":"HB1234"}`,
+		"router1-2024-01-02.json.sha256": "checksum",
+		"router2-2024-01-02.json":        `{"identity":"router2","host":"10.0.0.2","serial_number":"HC5678"}`,
+		"router3-2024-01-02.json.enc":    "encrypted",
+	}
+
+	for name, content := range manifests {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := LastManifests(context.Background(), storage.NewLocalDir(dir), nil, nil)
+	if err != nil {
+		t.Fatalf("LastManifests() error: %v", err)
+	}
+
+	want := map[string]Manifest{
+		"10.0.0.1": {Identity: "router1", Host: "10.0.0.1", ID: "HB1234"},
+		"10.0.0.2": {Identity: "router2", Host: "10.0.0.2", SerialNumber: "HC5678"},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LastManifests() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,96 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ZeljkoBenovic/gombak/pkg/compress"
+	"github.com/ZeljkoBenovic/gombak/pkg/encrypt"
+)
+
+// InsufficientSpaceError is returned when the router storage has less free space than the backup files are expected to take
+type InsufficientSpaceError struct {
+	Host     string
+	Free     int64
+	Required int64
+}
+
+func (e *InsufficientSpaceError) Error() string {
+	return fmt.Sprintf("insufficient space on %s: %d bytes free, %d bytes required", e.Host, e.Free, e.Required)
+}
+
+// GetFreeSpace returns the free space of the router storage in bytes
+func (b *Backup) GetFreeSpace(ctx context.Context) (int64, error) {
+	out, err := b.run(ctx, ":put [/system resource get free-hdd-space]")
+	if err != nil {
+		return 0, fmt.Errorf("could not get free space: %w", err)
+	}
+
+	free, err := strconv.ParseInt(strings.TrimSpace(out), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse free space %q: %w", strings.TrimSpace(out), err)
+	}
+
+	return free, nil
+}
+
+// expectedSize returns the size of the artifact before it was compressed and encrypted, recorded in the last manifest.
+// Without the size in the manifest, the size of the last stored file of the artifact kind is used, if it is not compressed.
+// It returns zero if the size is not known.
+func (b *Backup) expectedSize(ctx context.Context, manifest Manifest, artifact, kind string) int64 {
+	for _, a := range manifest.Artifacts {
+		if a.Name == artifact && a.Size > 0 {
+			return a.Size
+		}
+	}
+
+	last, ok := b.lastStored(ctx, kind)
+	if !ok {
+		return 0
+	}
+
+	// the compressed files are not downloaded to learn their size
+	if compress.Detect(strings.TrimSuffix(last.Name, encrypt.Ext)) != compress.None {
+		return 0
+	}
+
+	// the encryption overhead is small enough to be ignored
+	return last.Size
+}
+
+// checkFreeSpace compares the free space of the router storage with the size of the files the backup writes to it,
+// expected from the artifact sizes recorded in the last manifest. It returns InsufficientSpaceError if there is not enough space.
+// The check is skipped if the size of the last binary backup is not known.
+func (b *Backup) checkFreeSpace(ctx context.Context) error {
+	manifest, _ := b.lastManifest(ctx)
+
+	required := b.expectedSize(ctx, manifest, "backup", "backup")
+	if required == 0 {
+		b.log.Debug("No stored backup size found - skipping free space check", "host", b.host)
+		return nil
+	}
+
+	// the export is written to the router storage, unless it is streamed
+	if !b.streamExport {
+		required += b.expectedSize(ctx, manifest, "export", "rsc")
+	}
+
+	free, err := b.GetFreeSpace(ctx)
+	if err != nil {
+		return err
+	}
+
+	b.log.Debug("Checking free space", "free", free, "required", required, "host", b.host)
+
+	if free < required {
+		return &InsufficientSpaceError{
+			Host:     b.host,
+			Free:     free,
+			Required: required,
+		}
+	}
+
+	return nil
+}
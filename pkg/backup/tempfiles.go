@@ -0,0 +1,86 @@
+package backup
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// TempFilePrefix is the name prefix of all files gombak creates on the router
+const TempFilePrefix = "gombak-"
+
+// legacyTempFiles are the fixed temp file names used by older gombak versions
+var legacyTempFiles = map[string]struct{}{
+	"ssh-backup.rsc":    {},
+	"ssh-backup.backup": {},
+}
+
+// newTempName returns a unique name for the files created on the router
+func newTempName(runID string) string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+
+	if runID == "" {
+		return TempFilePrefix + hex.EncodeToString(b)
+	}
+
+	return fmt.Sprintf("%s%s-%s", TempFilePrefix, runID, hex.EncodeToString(b))
+}
+
+// remoteFile returns the router path of the temp file with the extension
+func (b *Backup) remoteFile(ext string) string {
+	return "/" + b.tempName + ext
+}
+
+// isStaleTempFile reports whether the router file was created by gombak, not by this backup, and is older than maxAge
+func (b *Backup) isStaleTempFile(name string, modTime time.Time, maxAge time.Duration) bool {
+	if strings.HasPrefix(name, b.tempName) {
+		return false
+	}
+
+	_, legacy := legacyTempFiles[name]
+	if !legacy && !strings.HasPrefix(name, TempFilePrefix) {
+		return false
+	}
+
+	if ext := path.Ext(name); ext != ".rsc" && ext != ".backup" && ext != ".p12" {
+		return false
+	}
+
+	return time.Since(modTime) > maxAge
+}
+
+// DeleteStaleTempFiles removes gombak temp files left on the router by earlier failed runs.
+// Only the files older than maxAge are removed, so the files of the backups still in progress are kept.
+func (b *Backup) DeleteStaleTempFiles(ctx context.Context, maxAge time.Duration) error {
+	listCtx, cancel := withTimeout(ctx, b.commandTimeout)
+	defer cancel()
+
+	files, err := b.cl.List(listCtx, "/")
+	if err != nil {
+		return fmt.Errorf("could not list router files: %w", err)
+	}
+
+	for _, f := range files {
+		if f.IsDir() || !b.isStaleTempFile(f.Name(), f.ModTime(), maxAge) {
+			continue
+		}
+
+		b.log.Info("Deleting stale temp file", "file_name", f.Name(), "host", b.host)
+
+		if err = b.deleteFile(ctx, "/"+f.Name()); err != nil {
+			b.log.Error(
+				"Stale temp file on the router could not be deleted",
+				"err", err.Error(),
+				"file_name", f.Name(),
+				"host", b.host,
+			)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	sshclient "github.com/ZeljkoBenovic/gombak/pkg/ssh"
+)
+
+// Binary backup header magic numbers, for plain, rc4 encrypted and aes-sha256 encrypted backups
+const (
+	backupMagicPlain = 0xB1A1AC88
+	backupMagicRC4   = 0x7291A8EF
+	backupMagicAES   = 0x7391A8EF
+)
+
+// backupHeaderSize is the size of the binary backup header, which holds the magic number and the file size
+const backupHeaderSize = 8
+
+// verifyRetryDelay is the time waited before the backup is retried after a failed verification
+const verifyRetryDelay = 5 * time.Second
+
+// VerificationError is returned when a downloaded backup file is not usable
+type VerificationError struct {
+	File   string
+	Reason string
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("verification of %s failed: %s", e.File, e.Reason)
+}
+
+// WithVerifyRetries sets how many times the backup is retried after a downloaded file fails verification or is incomplete
+func WithVerifyRetries(retries int) Opts {
+	return func(b *Backup) {
+		b.verifyRetries = retries
+	}
+}
+
+// verifyBackupFile checks that the binary backup has a known header, and that the size in the header matches the file size.
+// The file is read from r, which holds size bytes.
+func verifyBackupFile(file string, r io.ReaderAt, size int64) error {
+	if size < backupHeaderSize {
+		return &VerificationError{File: file, Reason: fmt.Sprintf("file too small: %d bytes", size)}
+	}
+
+	header := make([]byte, backupHeaderSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return &VerificationError{File: file, Reason: fmt.Sprintf("could not read header: %s", err)}
+	}
+
+	switch magic := binary.LittleEndian.Uint32(header[:4]); magic {
+	case backupMagicPlain, backupMagicRC4, backupMagicAES:
+	default:
+		return &VerificationError{File: file, Reason: fmt.Sprintf("unknown header %08x", magic)}
+	}
+
+	if headerSize := binary.LittleEndian.Uint32(header[4:8]); int64(headerSize) != size {
+		return &VerificationError{File: file, Reason: fmt.Sprintf("header size %d does not match file size %d", headerSize, size)}
+	}
+
+	return nil
+}
+
+// verifyExport checks that the export starts with the RouterOS header, and that it is not truncated
+// in the middle of a line or a continued command
+func verifyExport(file string, data []byte) error {
+	if loc := exportHeader.FindIndex(data); loc == nil || loc[0] != 0 {
+		return &VerificationError{File: file, Reason: "RouterOS export header not found"}
+	}
+
+	if !bytes.HasSuffix(data, []byte("\n")) {
+		return &VerificationError{File: file, Reason: "export does not end with a new line"}
+	}
+
+	if bytes.HasSuffix(bytes.TrimRight(data, "\r\n"), []byte(`\`)) {
+		return &VerificationError{File: file, Reason: "export ends with a continued command"}
+	}
+
+	return nil
+}
+
+// retryVerification runs the backup, and runs it again if a downloaded file fails verification or is incomplete,
+// up to the verify retries
+func (b *Backup) retryVerification(ctx context.Context, run func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := run()
+
+		var (
+			verifyErr     *VerificationError
+			incompleteErr *sshclient.IncompleteDownloadError
+		)
+
+		if !errors.As(err, &verifyErr) && !errors.As(err, &incompleteErr) || attempt > b.verifyRetries {
+			return err
+		}
+
+		b.log.Warn("Backup verification failed - retrying", "err", err.Error(), "attempt", attempt, "host", b.host)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(verifyRetryDelay):
+		}
+	}
+}
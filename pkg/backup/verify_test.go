@@ -0,0 +1,100 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func backupFile(magic uint32, headerSize uint32, size int) []byte {
+	data := make([]byte, size)
+
+	if size >= backupHeaderSize {
+		binary.LittleEndian.PutUint32(data[:4], magic)
+		binary.LittleEndian.PutUint32(data[4:8], headerSize)
+	}
+
+	return data
+}
+
+func TestVerifyBackupFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{name: "plain", data: backupFile(backupMagicPlain, 64, 64)},
+		{name: "rc4 encrypted", data: backupFile(backupMagicRC4, 64, 64)},
+		{name: "aes encrypted", data: backupFile(backupMagicAES, 64, 64)},
+		{name: "header only", data: backupFile(backupMagicPlain, backupHeaderSize, backupHeaderSize)},
+		{name: "empty", data: nil, wantErr: true},
+		{name: "too small", data: []byte{0x88, 0xAC, 0xA1}, wantErr: true},
+		{name: "unknown header", data: backupFile(0xDEADBEEF, 64, 64), wantErr: true},
+		{name: "truncated", data: backupFile(backupMagicPlain, 64, 32), wantErr: true},
+		{name: "trailing data", data: backupFile(backupMagicAES, 64, 128), wantErr: true},
+		{name: "export instead of backup", data: []byte("# jan/02/2024 10:00:00 by RouterOS 7.12\n"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyBackupFile("router1.backup", bytes.NewReader(tt.data), int64(len(tt.data)))
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("verifyBackupFile() error: %v", err)
+				}
+
+				return
+			}
+
+			var verifyErr *VerificationError
+			if !errors.As(err, &verifyErr) {
+				t.Fatalf("verifyBackupFile() error = %v, want verification error", err)
+			}
+
+			if verifyErr.File != "router1.backup" {
+				t.Errorf("verification error file = %q, want router1.backup", verifyErr.File)
+			}
+		})
+	}
+}
+
+func TestVerifyExport(t *testing.T) {
+	header := "# jan/02/2024 10:00:00 by RouterOS 7.12\n# software id = ABCD-1234\n"
+
+	tests := []struct {
+		name    string
+		export  string
+		wantErr bool
+	}{
+		{name: "export", export: header + "/system identity\nset name=router1\n"},
+		{name: "windows line endings", export: "# jan/02/2024 10:00:00 by RouterOS 6.49.10\r\n/system identity\r\nset name=router1\r\n"},
+		{name: "v7 header", export: "# 2024-01-02 10:00:00 by RouterOS 7.15\n/system identity\nset name=router1\n"},
+		{name: "wrapped command", export: header + "/ip address\nadd address=10.0.0.1/24 \\\n    interface=ether1\n"},
+		{name: "header only", export: header},
+		{name: "empty", export: "", wantErr: true},
+		{name: "no header", export: "/system identity\nset name=router1\n", wantErr: true},
+		{name: "header not at the start", export: "/system identity\n" + header, wantErr: true},
+		{name: "error message", export: "failure: not enough space\n", wantErr: true},
+		{name: "truncated line", export: header + "/system identity\nset name=rou", wantErr: true},
+		{name: "truncated continued command", export: header + "/ip address\nadd address=10.0.0.1/24 \\\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyExport("router1.rsc", []byte(tt.export))
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("verifyExport() error: %v", err)
+				}
+
+				return
+			}
+
+			var verifyErr *VerificationError
+			if !errors.As(err, &verifyErr) {
+				t.Fatalf("verifyExport() error = %v, want verification error", err)
+			}
+		})
+	}
+}
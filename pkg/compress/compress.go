@@ -0,0 +1,134 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type Algorithm string
+
+const (
+	None Algorithm = "none"
+	Gzip Algorithm = "gzip"
+	Zstd Algorithm = "zstd"
+)
+
+var Algorithms = map[string]Algorithm{
+	"none": None,
+	"gzip": Gzip,
+	"zstd": Zstd,
+}
+
+// extensions are the file name extensions of the compressed files
+var extensions = map[Algorithm]string{
+	Gzip: ".gz",
+	Zstd: ".zst",
+}
+
+// Ext returns the file name extension added to the files compressed with the algorithm
+func (a Algorithm) Ext() string {
+	return extensions[a]
+}
+
+// Compress returns the data compressed with the algorithm
+func (a Algorithm) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w, err := a.NewWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = w.Write(data); err != nil {
+		return nil, fmt.Errorf("could not compress data: %w", err)
+	}
+
+	if err = w.Close(); err != nil {
+		return nil, fmt.Errorf("could not compress data: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// NewWriter returns a writer which compresses the data written to it with the algorithm and writes it to w.
+// The writer must be closed to flush the compressed data. With no compression the data is written as it is.
+func (a Algorithm) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	switch a {
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	case Zstd:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, fmt.Errorf("could not create zstd writer: %w", err)
+		}
+
+		return zw, nil
+	default:
+		return nopWriteCloser{w}, nil
+	}
+}
+
+// nopWriteCloser is a writer with a Close method which does nothing
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// Detect returns the algorithm the file was compressed with, based on its name
+func Detect(name string) Algorithm {
+	for a, ext := range extensions {
+		if strings.HasSuffix(name, ext) {
+			return a
+		}
+	}
+
+	return None
+}
+
+// TrimExt returns the file name without the compression extension
+func TrimExt(name string) string {
+	return strings.TrimSuffix(name, Detect(name).Ext())
+}
+
+// NewReader returns a reader which decompresses r, based on the file name extension.
+// Files without a compression extension are read as they are.
+func NewReader(name string, r io.Reader) (io.ReadCloser, error) {
+	switch Detect(name) {
+	case Gzip:
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("could not read gzip file: %w", err)
+		}
+
+		return gr, nil
+	case Zstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("could not read zstd file: %w", err)
+		}
+
+		return zr.IOReadCloser(), nil
+	default:
+		return io.NopCloser(r), nil
+	}
+}
+
+// Decompress returns the content of the file, decompressed based on the file name extension
+func Decompress(name string, data []byte) ([]byte, error) {
+	r, err := NewReader(name, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
@@ -5,8 +5,13 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/ZeljkoBenovic/gombak/pkg/compress"
+	sshclient "github.com/ZeljkoBenovic/gombak/pkg/ssh"
+	"github.com/ZeljkoBenovic/gombak/pkg/storage"
 	"github.com/knadh/koanf/parsers/yaml"
 	"github.com/knadh/koanf/providers/env"
 	"github.com/knadh/koanf/providers/file"
@@ -37,26 +42,208 @@ type Config struct {
 	Single              RouterInfo   `koanf:"single"`
 	Discovery           Discovery    `koanf:"discovery"`
 	Multi               []RouterInfo `koanf:"multi-router"`
+	// Retention is the grandfather-father-son retention policy, which replaces BackupRetentionDays for backups when set
+	Retention Retention `koanf:"retention"`
+
+	HostKeyMode    sshclient.HostKeyMode `koanf:"host-key-mode"`
+	KnownHostsFile string                `koanf:"known-hosts-file"`
+	JumpHosts      []JumpHost            `koanf:"jump-hosts"`
+
+	Timeouts     Timeouts `koanf:"timeouts"`
+	StreamExport bool     `koanf:"stream-export"`
+	// VerifyRetries is the number of times the router backup is retried after a downloaded file fails verification or is incomplete
+	VerifyRetries int `koanf:"verify-retries"`
+	// StaleTempFilesAge is the age after which gombak temp files left on the router are removed
+	StaleTempFilesAge time.Duration `koanf:"stale-temp-files-age"`
+	// UnchangedExport defines what is done with an export which did not change since the last backup
+	UnchangedExport string `koanf:"unchanged-export"`
+	// ExportStorage is where the exports are stored: file, in the backup dir, or git, in the GitDir repository
+	ExportStorage string `koanf:"export-storage"`
+	GitDir        string `koanf:"git-dir"`
+	// Storage is where the backup files are stored, the backup dir by default
+	Storage     Storage            `koanf:"storage"`
+	Compression compress.Algorithm `koanf:"compression"`
+	// Layout is the template of the stored backup file paths
+	Layout string `koanf:"layout"`
+	// EncryptionKeyFile is the AES-256 key file used to encrypt the stored backup files
+	EncryptionKeyFile string `koanf:"encryption-key-file"`
+
+	BackupPassword   string `koanf:"backup-password"`
+	BackupEncryption string `koanf:"backup-encryption"`
+	Export           Export `koanf:"export"`
+	// Artifacts are the router files backed up next to the export and the binary backup
+	Artifacts Artifacts `koanf:"artifacts"`
+	// Redact holds the rules used to redact the secrets in exports before they are stored
+	Redact Redact `koanf:"redact"`
 
 	Logger Log `koanf:"log"`
 
+	// Command holds the settings of the commands run on the stored backup files, such as decrypt
+	Command Command
+
 	ConfigFilePath string
 }
 
+// Command holds the command line settings of the gombak commands
+type Command struct {
+	// File is the backup file the command is run on
+	File string
+	// Out is the output file, derived from File if not set
+	Out string
+	// Host is the router the backup is restored to, single mode router by default
+	Host string
+	// DryRun previews the restore without changing the router
+	DryRun bool
+	// Confirm must be set to restore the backup
+	Confirm bool
+}
+
+const (
+	FileStorage = "file"
+	GitStorage  = "git"
+)
+
+var ExportStorages = map[string]struct{}{
+	FileStorage: {},
+	GitStorage:  {},
+}
+
+// Retention holds the number of backups kept for each router, in each period
+type Retention struct {
+	KeepLast int `koanf:"keep-last"`
+	Daily    int `koanf:"daily"`
+	Weekly   int `koanf:"weekly"`
+	Monthly  int `koanf:"monthly"`
+	Yearly   int `koanf:"yearly"`
+	// MinKeep is the number of latest backups of each router which are never deleted
+	MinKeep int `koanf:"min-keep"`
+	// FailedRouters defines how the backups of the routers which failed in the run are cleaned up: skip or warn
+	FailedRouters string `koanf:"failed-routers"`
+}
+
+// Storage holds the backup storage backend settings
+type Storage struct {
+	Type storage.Type `koanf:"type"`
+	S3   S3Storage    `koanf:"s3"`
+	SFTP SFTPStorage  `koanf:"sftp"`
+}
+
+// S3Storage is an S3 compatible object store bucket
+type S3Storage struct {
+	Endpoint  string `koanf:"endpoint"`
+	Region    string `koanf:"region"`
+	Bucket    string `koanf:"bucket"`
+	Prefix    string `koanf:"prefix"`
+	AccessKey string `koanf:"access-key"`
+	SecretKey string `koanf:"secret-key"`
+	Insecure  bool   `koanf:"insecure"`
+}
+
+// SFTPStorage is a directory on a remote sftp server
+type SFTPStorage struct {
+	Host           string `koanf:"host"`
+	Port           string `koanf:"ssh-port"`
+	Username       string `koanf:"username"`
+	Password       string `koanf:"password"`
+	KeyFile        string `koanf:"key-file"`
+	KeyPassphrase  string `koanf:"key-passphrase"`
+	SSHAgent       bool   `koanf:"ssh-agent"`
+	SSHAgentSocket string `koanf:"ssh-agent-socket"`
+	Dir            string `koanf:"dir"`
+}
+
 type RouterInfo struct {
-	Host     string `koanf:"host"`
-	Port     string `koanf:"ssh-port"`
-	Username string `koanf:"username"`
-	Password string `koanf:"password"`
+	Host           string `koanf:"host"`
+	Port           string `koanf:"ssh-port"`
+	Username       string `koanf:"username"`
+	Password       string `koanf:"password"`
+	KeyFile        string `koanf:"key-file"`
+	KeyPassphrase  string `koanf:"key-passphrase"`
+	SSHAgent       bool   `koanf:"ssh-agent"`
+	SSHAgentSocket string `koanf:"ssh-agent-socket"`
+	// Group is the router group, which can be used in the layout
+	Group string `koanf:"group"`
+	// JumpHosts override the global jump hosts for this router
+	JumpHosts []JumpHost `koanf:"jump-hosts"`
+	// BackupPassword and BackupEncryption override the global binary backup encryption for this router
+	BackupPassword   string `koanf:"backup-password"`
+	BackupEncryption string `koanf:"backup-encryption"`
+	// Export overrides the global export parameters for this router
+	Export *Export `koanf:"export"`
+}
+
+// JumpHost is a bastion host used to reach the routers
+type JumpHost struct {
+	Host           string `koanf:"host"`
+	Port           string `koanf:"ssh-port"`
+	Username       string `koanf:"username"`
+	Password       string `koanf:"password"`
+	KeyFile        string `koanf:"key-file"`
+	KeyPassphrase  string `koanf:"key-passphrase"`
+	SSHAgent       bool   `koanf:"ssh-agent"`
+	SSHAgentSocket string `koanf:"ssh-agent-socket"`
 }
 
 type Discovery struct {
-	Hosts      []string `koanf:"hosts"`
-	Username   string   `koanf:"username"`
-	Password   string   `koanf:"password"`
-	APIPort    string   `koanf:"api-port"`
-	APISSLPort string   `koanf:"api-ssl-port"`
-	SSHPort    string   `konaf:"ssh-port"`
+	Hosts          []string `koanf:"hosts"`
+	Username       string   `koanf:"username"`
+	Password       string   `koanf:"password"`
+	APIPort        string   `koanf:"api-port"`
+	APISSLPort     string   `koanf:"api-ssl-port"`
+	SSHPort        string   `konaf:"ssh-port"`
+	KeyFile        string   `koanf:"key-file"`
+	KeyPassphrase  string   `koanf:"key-passphrase"`
+	SSHAgent       bool     `koanf:"ssh-agent"`
+	SSHAgentSocket string   `koanf:"ssh-agent-socket"`
+	// Group is the group of the discovered routers, which can be used in the layout
+	Group string `koanf:"group"`
+	// JumpHosts override the global jump hosts for discovered routers
+	JumpHosts []JumpHost `koanf:"jump-hosts"`
+	// BackupPassword and BackupEncryption override the global binary backup encryption for discovered routers
+	BackupPassword   string `koanf:"backup-password"`
+	BackupEncryption string `koanf:"backup-encryption"`
+	// Export overrides the global export parameters for discovered routers
+	Export *Export `koanf:"export"`
+}
+
+// Export holds the /export command parameters
+type Export struct {
+	// ShowSensitive is left unset to use the RouterOS version default
+	ShowSensitive *bool `koanf:"show-sensitive"`
+	Compact       bool  `koanf:"compact"`
+	Verbose       bool  `koanf:"verbose"`
+	Terse         bool  `koanf:"terse"`
+}
+
+// Artifacts holds the extra artifacts fetched from each router
+type Artifacts struct {
+	Users                 bool     `koanf:"users"`
+	Certificates          bool     `koanf:"certificates"`
+	CertificatePassphrase string   `koanf:"certificate-passphrase"`
+	Scripts               bool     `koanf:"scripts"`
+	Files                 []string `koanf:"files"`
+}
+
+// Redact holds the export redaction settings
+type Redact struct {
+	Enabled bool `koanf:"enabled"`
+	// Rules replace the default redaction rules when set
+	Rules []RedactRule `koanf:"rules"`
+	// OriginalsDir keeps the unredacted exports, encrypted with the key from OriginalsKeyFile
+	OriginalsDir     string `koanf:"originals-dir"`
+	OriginalsKeyFile string `koanf:"originals-key-file"`
+}
+
+// RedactRule redacts the values of the params, in the menu section of the export or in all sections if the menu is not set
+type RedactRule struct {
+	Menu   string   `koanf:"menu"`
+	Params []string `koanf:"params"`
+}
+
+type Timeouts struct {
+	Connect  time.Duration `koanf:"connect"`
+	Command  time.Duration `koanf:"command"`
+	Transfer time.Duration `koanf:"transfer"`
 }
 
 type Log struct {
@@ -66,9 +253,9 @@ type Log struct {
 }
 
 var (
-	ErrSingleHostNotFound     = errors.New("single mode router ip not found")
-	ErrSingleUserNotFound     = errors.New("single mode username not found")
-	ErrSinglePasswordNotFound = errors.New("single mode password not found")
+	ErrSingleHostNotFound = errors.New("single mode router ip not found")
+	ErrSingleUserNotFound = errors.New("single mode username not found")
+	ErrSingleAuthNotFound = errors.New("single mode password, private key or ssh agent not found")
 
 	ErrDiscoveryHostsNotFound = errors.New("discovery mode router ip addresses not found")
 	ErrDiscoveryUserNotFound  = errors.New("discovery mode username not found")
@@ -79,10 +266,22 @@ var k = koanf.New(".")
 
 func NewConfig() Config {
 	var (
-		c        = Config{}
-		confFile string
-		mode     string
-		mrList   []RouterInfo
+		c           = Config{}
+		confFile    string
+		mode        string
+		hostKeyMode string
+		storageType string
+		compression string
+		mrList      []RouterInfo
+
+		jumpHosts          []JumpHost
+		singleJumpHosts    []JumpHost
+		discoveryJumpHosts []JumpHost
+
+		export          Export
+		singleExport    *Export
+		discoveryExport *Export
+		redactRules     []RedactRule
 	)
 
 	f := flag.NewFlagSet("config", flag.ContinueOnError)
@@ -96,11 +295,75 @@ func NewConfig() Config {
 	f.StringVarP(&mode, "mode", "m", "single", "mode of operation")
 	f.IntVarP(&c.BackupRetentionDays, "backup-retention-days", "r", 30, "days of retention")
 	f.IntVarP(&c.BackupFrequencyDays, "backup-frequency-days", "", 5, "backup frequency in days")
+	f.IntVarP(&c.Retention.KeepLast, "retention.keep-last", "", 0, "keep this many latest backups of each router")
+	f.IntVarP(&c.Retention.Daily, "retention.daily", "", 0, "keep the latest backup of each router for this many days")
+	f.IntVarP(&c.Retention.Weekly, "retention.weekly", "", 0, "keep the latest backup of each router for this many weeks")
+	f.IntVarP(&c.Retention.Monthly, "retention.monthly", "", 0, "keep the latest backup of each router for this many months")
+	f.IntVarP(&c.Retention.Yearly, "retention.yearly", "", 0, "keep the latest backup of each router for this many years")
+	f.IntVarP(&c.Retention.MinKeep, "retention.min-keep", "", 3, "never delete this many latest backups of each router")
+	f.StringVarP(&c.Retention.FailedRouters, "retention.failed-routers", "", "skip", "cleanup of the routers which backup failed in the run: skip or warn")
+
+	f.StringVarP(&hostKeyMode, "host-key-mode", "", "tofu", "host key verification mode: strict, tofu or ignore")
+	f.StringVarP(&c.KnownHostsFile, "known-hosts-file", "", "", "known hosts file used for host key verification (default gombak managed file)")
+
+	f.StringVarP(&c.BackupPassword, "backup-password", "", "", "encrypt binary backups with this password")
+	f.StringVarP(&c.BackupEncryption, "backup-encryption", "", "aes-sha256", "binary backup encryption: aes-sha256 or rc4")
+	f.BoolVarP(&c.Artifacts.Users, "artifacts.users", "", false, "back up the /user export")
+	f.BoolVarP(&c.Artifacts.Certificates, "artifacts.certificates", "", false, "back up the certificates with their private keys")
+	f.StringVarP(&c.Artifacts.CertificatePassphrase, "artifacts.certificate-passphrase", "", "", "encrypt the backed up certificates with this passphrase")
+	f.BoolVarP(&c.Artifacts.Scripts, "artifacts.scripts", "", false, "back up each /system script in its own file")
+	f.StringSliceVarP(&c.Artifacts.Files, "artifacts.files", "", nil, "back up the router files matching these glob patterns")
+	f.BoolVarP(&c.Redact.Enabled, "redact.enabled", "", false, "redact passwords, keys and secrets in exports before they are stored")
+	f.StringVarP(&c.Redact.OriginalsDir, "redact.originals-dir", "", "", "keep the unredacted exports encrypted in this directory")
+	f.StringVarP(&c.Redact.OriginalsKeyFile, "redact.originals-key-file", "", "", "encrypt the unredacted exports with the AES-256 key from this file")
+	f.BoolVarP(&c.StreamExport, "stream-export", "", false, "capture the export over ssh instead of writing it to the router storage")
+
+	f.StringVarP(&c.ExportStorage, "export-storage", "", "file", "where exports are stored: file or git")
+	f.StringVarP(&c.GitDir, "git-dir", "", "", "git repository for exports (default <backup-dir>/git)")
+	f.StringVarP(&c.UnchangedExport, "unchanged-export", "", "store", "what to do with an unchanged export: store, skip or touch")
+	f.DurationVarP(&c.StaleTempFilesAge, "stale-temp-files-age", "", 6*time.Hour, "remove gombak temp files older than this from the routers")
+
+	f.StringVarP(&c.Layout, "layout", "", "{identity}-{date}", "stored backup file path template, using {identity}, {ip}, {serial}, {group}, {date}, {time} and {run_id}")
+	f.StringVarP(&c.EncryptionKeyFile, "encryption-key-file", "", "", "encrypt stored backup files with the AES-256 key from this file")
+	f.StringVarP(&compression, "compression", "", "none", "compress stored backup files: none, gzip or zstd")
+	f.StringVarP(&storageType, "storage.type", "", "local", "backup storage: local, s3 or sftp")
+	f.StringVarP(&c.Storage.S3.Endpoint, "storage.s3.endpoint", "", "s3.amazonaws.com", "s3 endpoint host and port")
+	f.StringVarP(&c.Storage.S3.Region, "storage.s3.region", "", "", "s3 bucket region")
+	f.StringVarP(&c.Storage.S3.Bucket, "storage.s3.bucket", "", "", "s3 bucket name")
+	f.StringVarP(&c.Storage.S3.Prefix, "storage.s3.prefix", "", "", "s3 object name prefix")
+	f.StringVarP(&c.Storage.S3.AccessKey, "storage.s3.access-key", "", "", "s3 access key")
+	f.StringVarP(&c.Storage.S3.SecretKey, "storage.s3.secret-key", "", "", "s3 secret key")
+	f.BoolVarP(&c.Storage.S3.Insecure, "storage.s3.insecure", "", false, "connect to the s3 endpoint over plain http")
+	f.StringVarP(&c.Storage.SFTP.Host, "storage.sftp.host", "", "", "sftp storage server address")
+	f.StringVarP(&c.Storage.SFTP.Port, "storage.sftp.ssh-port", "", "22", "sftp storage server ssh port")
+	f.StringVarP(&c.Storage.SFTP.Username, "storage.sftp.username", "", "", "sftp storage username")
+	f.StringVarP(&c.Storage.SFTP.Password, "storage.sftp.password", "", "", "sftp storage password")
+	f.StringVarP(&c.Storage.SFTP.KeyFile, "storage.sftp.key-file", "", "", "sftp storage private key file")
+	f.StringVarP(&c.Storage.SFTP.KeyPassphrase, "storage.sftp.key-passphrase", "", "", "the passphrase of the encrypted sftp storage private key")
+	f.BoolVarP(&c.Storage.SFTP.SSHAgent, "storage.sftp.ssh-agent", "", false, "use ssh agent for sftp storage authentication")
+	f.StringVarP(&c.Storage.SFTP.SSHAgentSocket, "storage.sftp.ssh-agent-socket", "", "", "the ssh agent socket (default $SSH_AUTH_SOCK)")
+	f.StringVarP(&c.Storage.SFTP.Dir, "storage.sftp.dir", "", "", "sftp storage directory")
+
+	f.IntVarP(&c.VerifyRetries, "verify-retries", "", 2, "retry the router backup this many times if a downloaded file fails verification or is incomplete")
+	f.DurationVarP(&c.Timeouts.Connect, "timeouts.connect", "", 30*time.Second, "ssh connection and handshake timeout")
+	f.DurationVarP(&c.Timeouts.Command, "timeouts.command", "", 5*time.Minute, "router command timeout")
+	f.DurationVarP(&c.Timeouts.Transfer, "timeouts.transfer", "", 10*time.Minute, "backup file download timeout")
 
 	f.StringVarP(&c.Single.Host, "single.host", "", "", "the ip address of the router")
 	f.StringVarP(&c.Single.Port, "single.ssh-port", "", "22", "the ssh port of the router")
 	f.StringVarP(&c.Single.Username, "single.user", "", "", "the username for the router")
 	f.StringVarP(&c.Single.Password, "single.pass", "", "", "the password for the username")
+	f.StringVarP(&c.Single.KeyFile, "single.key-file", "", "", "the private key file used for ssh authentication")
+	f.StringVarP(&c.Single.KeyPassphrase, "single.key-passphrase", "", "", "the passphrase of the encrypted private key")
+	f.BoolVarP(&c.Single.SSHAgent, "single.ssh-agent", "", false, "use ssh agent for authentication")
+	f.StringVarP(&c.Single.SSHAgentSocket, "single.ssh-agent-socket", "", "", "the ssh agent socket (default $SSH_AUTH_SOCK)")
+	f.StringVarP(&c.Single.Group, "single.group", "", "", "the router group, used in the layout")
+
+	f.StringVarP(&c.Command.File, "file", "", "", "the backup file used by the decrypt and restore commands")
+	f.StringVarP(&c.Command.Out, "out", "", "", "the decrypt command output file (default the file name without the .enc extension)")
+	f.StringVarP(&c.Command.Host, "host", "", "", "the router the restore command uploads the backup to (default single.host)")
+	f.BoolVarP(&c.Command.DryRun, "dry-run", "", false, "preview the restore without changing the router")
+	f.BoolVarP(&c.Command.Confirm, "confirm", "", false, "confirm the restore, which overwrites the router configuration")
 
 	f.BoolVarP(&c.Logger.JSONOutput, "log.json", "", false, "output logs in json format")
 	f.StringVarP(&c.Logger.File, "log.file", "", "", "write logs to the specified file")
@@ -132,6 +395,33 @@ func NewConfig() Config {
 		log.Fatalln("Selected mode not available")
 	}
 
+	if _, ok := ExportStorages[k.String("export-storage")]; !ok {
+		log.Fatalln("Selected export storage not available")
+	}
+
+	if _, ok := storage.Types[k.String("storage.type")]; !ok {
+		log.Fatalln("Selected storage type not available")
+	}
+
+	if _, ok := compress.Algorithms[k.String("compression")]; !ok {
+		log.Fatalln("Selected compression not available")
+	}
+
+	gitDir := k.String("git-dir")
+	if gitDir == "" {
+		gitDir = filepath.Join(k.String("backup-dir"), "git")
+	}
+
+	// git versioned exports are not encrypted, so they must not end up next to the encrypted backups
+	if k.String("export-storage") == GitStorage && k.String("encryption-key-file") != "" &&
+		storage.Types[k.String("storage.type")] == storage.Local && isSubdir(k.String("backup-dir"), gitDir) {
+		log.Fatalln("Git versioned exports are not encrypted - set git-dir outside of backup-dir when encryption-key-file is set")
+	}
+
+	if _, ok := sshclient.HostKeyModes[k.String("host-key-mode")]; !ok {
+		log.Fatalln("Selected host key mode not available")
+	}
+
 	mr, ok := k.Get("multi-router").([]any)
 	if mr != nil && !ok {
 		log.Fatalln("Could not cast multi-router to []RouterInfo")
@@ -141,25 +431,150 @@ func NewConfig() Config {
 		log.Fatalln("Could not unmarshal router list")
 	}
 
+	if err := k.Unmarshal("jump-hosts", &jumpHosts); err != nil {
+		log.Fatalln("Could not unmarshal jump hosts")
+	}
+
+	if err := k.Unmarshal("single.jump-hosts", &singleJumpHosts); err != nil {
+		log.Fatalln("Could not unmarshal single mode jump hosts")
+	}
+
+	if err := k.Unmarshal("discovery.jump-hosts", &discoveryJumpHosts); err != nil {
+		log.Fatalln("Could not unmarshal discovery mode jump hosts")
+	}
+
+	if err := k.Unmarshal("export", &export); err != nil {
+		log.Fatalln("Could not unmarshal export parameters")
+	}
+
+	if err := k.Unmarshal("redact.rules", &redactRules); err != nil {
+		log.Fatalln("Could not unmarshal redact rules")
+	}
+
+	if k.Exists("single.export") {
+		if err := k.Unmarshal("single.export", &singleExport); err != nil {
+			log.Fatalln("Could not unmarshal single mode export parameters")
+		}
+	}
+
+	if k.Exists("discovery.export") {
+		if err := k.Unmarshal("discovery.export", &discoveryExport); err != nil {
+			log.Fatalln("Could not unmarshal discovery mode export parameters")
+		}
+	}
+
 	return Config{
 		BackupFolder:        k.String("backup-dir"),
 		BackupRetentionDays: k.Int("backup-retention-days"),
 		BackupFrequencyDays: k.Int("backup-frequency-days"),
-		ConfigFilePath:      k.String("config"),
-		Mode:                AvailableModes[k.String("mode")],
+		Retention: Retention{
+			KeepLast: k.Int("retention.keep-last"),
+			Daily:    k.Int("retention.daily"),
+			Weekly:   k.Int("retention.weekly"),
+			Monthly:  k.Int("retention.monthly"),
+			Yearly:   k.Int("retention.yearly"),
+
+			MinKeep:       k.Int("retention.min-keep"),
+			FailedRouters: k.String("retention.failed-routers"),
+		},
+		ConfigFilePath:    k.String("config"),
+		Mode:              AvailableModes[k.String("mode")],
+		HostKeyMode:       sshclient.HostKeyModes[k.String("host-key-mode")],
+		KnownHostsFile:    k.String("known-hosts-file"),
+		JumpHosts:         jumpHosts,
+		StreamExport:      k.Bool("stream-export"),
+		VerifyRetries:     k.Int("verify-retries"),
+		StaleTempFilesAge: k.Duration("stale-temp-files-age"),
+		UnchangedExport:   k.String("unchanged-export"),
+		ExportStorage:     k.String("export-storage"),
+		GitDir:            gitDir,
+		Compression:       compress.Algorithms[k.String("compression")],
+		Layout:            k.String("layout"),
+		EncryptionKeyFile: k.String("encryption-key-file"),
+		Storage: Storage{
+			Type: storage.Types[k.String("storage.type")],
+			S3: S3Storage{
+				Endpoint:  k.String("storage.s3.endpoint"),
+				Region:    k.String("storage.s3.region"),
+				Bucket:    k.String("storage.s3.bucket"),
+				Prefix:    k.String("storage.s3.prefix"),
+				AccessKey: k.String("storage.s3.access-key"),
+				SecretKey: k.String("storage.s3.secret-key"),
+				Insecure:  k.Bool("storage.s3.insecure"),
+			},
+			SFTP: SFTPStorage{
+				Host:           k.String("storage.sftp.host"),
+				Port:           k.String("storage.sftp.ssh-port"),
+				Username:       k.String("storage.sftp.username"),
+				Password:       k.String("storage.sftp.password"),
+				KeyFile:        k.String("storage.sftp.key-file"),
+				KeyPassphrase:  k.String("storage.sftp.key-passphrase"),
+				SSHAgent:       k.Bool("storage.sftp.ssh-agent"),
+				SSHAgentSocket: k.String("storage.sftp.ssh-agent-socket"),
+				Dir:            k.String("storage.sftp.dir"),
+			},
+		},
+		BackupPassword:   k.String("backup-password"),
+		BackupEncryption: k.String("backup-encryption"),
+		Export:           export,
+		Artifacts: Artifacts{
+			Users:                 k.Bool("artifacts.users"),
+			Certificates:          k.Bool("artifacts.certificates"),
+			CertificatePassphrase: k.String("artifacts.certificate-passphrase"),
+			Scripts:               k.Bool("artifacts.scripts"),
+			Files:                 k.Strings("artifacts.files"),
+		},
+		Redact: Redact{
+			Enabled:          k.Bool("redact.enabled"),
+			Rules:            redactRules,
+			OriginalsDir:     k.String("redact.originals-dir"),
+			OriginalsKeyFile: k.String("redact.originals-key-file"),
+		},
+		Timeouts: Timeouts{
+			Connect:  k.Duration("timeouts.connect"),
+			Command:  k.Duration("timeouts.command"),
+			Transfer: k.Duration("timeouts.transfer"),
+		},
 		Single: RouterInfo{
-			Host:     k.String("single.host"),
-			Port:     k.String("single.ssh-port"),
-			Username: k.String("single.user"),
-			Password: k.String("single.pass"),
+			Host:           k.String("single.host"),
+			Port:           k.String("single.ssh-port"),
+			Username:       k.String("single.user"),
+			Password:       k.String("single.pass"),
+			KeyFile:        k.String("single.key-file"),
+			KeyPassphrase:  k.String("single.key-passphrase"),
+			SSHAgent:       k.Bool("single.ssh-agent"),
+			SSHAgentSocket: k.String("single.ssh-agent-socket"),
+			Group:          k.String("single.group"),
+			JumpHosts:      singleJumpHosts,
+
+			BackupPassword:   k.String("single.backup-password"),
+			BackupEncryption: k.String("single.backup-encryption"),
+			Export:           singleExport,
 		},
 		Multi: mrList,
 		Discovery: Discovery{
-			Hosts:    k.Strings("discovery.hosts"),
-			Username: k.String("discovery.username"),
-			Password: k.String("discovery.password"),
-			APIPort:  k.String("discovery.api-port"),
-			SSHPort:  k.String("discovery.ssh-port"),
+			Hosts:          k.Strings("discovery.hosts"),
+			Username:       k.String("discovery.username"),
+			Password:       k.String("discovery.password"),
+			APIPort:        k.String("discovery.api-port"),
+			SSHPort:        k.String("discovery.ssh-port"),
+			KeyFile:        k.String("discovery.key-file"),
+			KeyPassphrase:  k.String("discovery.key-passphrase"),
+			SSHAgent:       k.Bool("discovery.ssh-agent"),
+			SSHAgentSocket: k.String("discovery.ssh-agent-socket"),
+			Group:          k.String("discovery.group"),
+			JumpHosts:      discoveryJumpHosts,
+
+			BackupPassword:   k.String("discovery.backup-password"),
+			BackupEncryption: k.String("discovery.backup-encryption"),
+			Export:           discoveryExport,
+		},
+		Command: Command{
+			File:    k.String("file"),
+			Out:     k.String("out"),
+			Host:    k.String("host"),
+			DryRun:  k.Bool("dry-run"),
+			Confirm: k.Bool("confirm"),
 		},
 		Logger: Log{
 			JSONOutput: k.Bool("log.json"),
@@ -182,8 +597,8 @@ func (c *Config) CheckSingleRequirements() error {
 		return ErrSingleUserNotFound
 	}
 
-	if c.Single.Password == "" {
-		return ErrSinglePasswordNotFound
+	if c.Single.Password == "" && c.Single.KeyFile == "" && !c.Single.SSHAgent {
+		return ErrSingleAuthNotFound
 	}
 
 	return nil
@@ -216,3 +631,23 @@ func (c *Config) CheckDiscoveryRequirements() error {
 
 	return nil
 }
+
+// isSubdir reports whether the dir is the parent dir or inside it
+func isSubdir(parent, dir string) bool {
+	parentAbs, err := filepath.Abs(parent)
+	if err != nil {
+		return false
+	}
+
+	dirAbs, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+
+	rel, err := filepath.Rel(parentAbs, dirAbs)
+	if err != nil {
+		return false
+	}
+
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,113 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Ext is the file name extension added to the encrypted files
+const Ext = ".enc"
+
+// KeySize is the AES-256 key size in bytes
+const KeySize = 32
+
+// magic is the header of the encrypted files, which also identifies the file format version
+var magic = []byte("GOMBAK\x00\x01")
+
+var (
+	ErrInvalidKey   = errors.New("encryption key must be 32 bytes, raw, hex or base64 encoded")
+	ErrNotEncrypted = errors.New("file is not encrypted by gombak")
+)
+
+// Key encrypts and decrypts files using AES-256-GCM
+type Key struct {
+	aead cipher.AEAD
+}
+
+// LoadKey reads the key from the key file.
+// The key can be stored as 32 raw bytes, or hex or base64 encoded, for example generated with `openssl rand -hex 32`.
+func LoadKey(file string) (*Key, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read encryption key file: %w", err)
+	}
+
+	key, err := decodeKey(data)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not create cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("could not create cipher: %w", err)
+	}
+
+	return &Key{
+		aead: aead,
+	}, nil
+}
+
+func decodeKey(data []byte) ([]byte, error) {
+	if len(data) == KeySize {
+		return data, nil
+	}
+
+	encoded := strings.TrimSpace(string(data))
+
+	if key, err := hex.DecodeString(encoded); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+
+	if key, err := base64.StdEncoding.DecodeString(encoded); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+
+	return nil, ErrInvalidKey
+}
+
+// Encrypt returns the encrypted data, prefixed with the file header and a random nonce
+func (k *Key) Encrypt(data []byte) ([]byte, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("could not generate nonce: %w", err)
+	}
+
+	out := make([]byte, 0, len(magic)+len(nonce)+len(data)+k.aead.Overhead())
+	out = append(out, magic...)
+	out = append(out, nonce...)
+
+	// the header is authenticated as well, so the format version can not be tampered with
+	return k.aead.Seal(out, nonce, data, magic), nil
+}
+
+// Decrypt returns the decrypted data. It fails if the data was modified or encrypted with another key.
+func (k *Key) Decrypt(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, magic) {
+		return nil, ErrNotEncrypted
+	}
+
+	data = data[len(magic):]
+	if len(data) < k.aead.NonceSize() {
+		return nil, ErrNotEncrypted
+	}
+
+	out, err := k.aead.Open(nil, data[:k.aead.NonceSize()], data[k.aead.NonceSize():], magic)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt file, wrong key or corrupted file: %w", err)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,156 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeKeyFile(t *testing.T, content []byte) string {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(file, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func newTestKey(t *testing.T) *Key {
+	t.Helper()
+
+	raw := make([]byte, KeySize)
+	if _, err := rand.Read(raw); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := LoadKey(writeKeyFile(t, raw))
+	if err != nil {
+		t.Fatalf("LoadKey() error: %v", err)
+	}
+
+	return key
+}
+
+func TestLoadKey(t *testing.T) {
+	raw := bytes.Repeat([]byte{0xAB}, KeySize)
+
+	tests := []struct {
+		name    string
+		content []byte
+		wantErr bool
+	}{
+		{name: "raw", content: raw},
+		{name: "hex", content: []byte(hex.EncodeToString(raw))},
+		{name: "hex with new line", content: []byte(hex.EncodeToString(raw) + "\n")},
+		{name: "base64", content: []byte(base64.StdEncoding.EncodeToString(raw) + "\n")},
+		{name: "short", content: raw[:16], wantErr: true},
+		{name: "short hex", content: []byte(hex.EncodeToString(raw[:20])), wantErr: true},
+		{name: "empty", content: nil, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadKey(writeKeyFile(t, tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadKey() error = %v, want error %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr && !errors.Is(err, ErrInvalidKey) {
+				t.Errorf("LoadKey() error = %v, want %v", err, ErrInvalidKey)
+			}
+		})
+	}
+
+	if _, err := LoadKey(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("LoadKey() of a missing file error = nil, want error")
+	}
+}
+
+func TestKeyRoundTrip(t *testing.T) {
+	key := newTestKey(t)
+
+	for _, data := range [][]byte{nil, []byte("/system identity\nset name=router1\n"), bytes.Repeat([]byte{0}, 1<<20)} {
+		encrypted, err := key.Encrypt(data)
+		if err != nil {
+			t.Fatalf("Encrypt() error: %v", err)
+		}
+
+		if !bytes.HasPrefix(encrypted, magic) {
+			t.Errorf("Encrypt() output does not start with the file header")
+		}
+
+		if len(data) > 0 && bytes.Contains(encrypted, data) {
+			t.Errorf("Encrypt() output contains the plain data")
+		}
+
+		decrypted, err := key.Decrypt(encrypted)
+		if err != nil {
+			t.Fatalf("Decrypt() error: %v", err)
+		}
+
+		if !bytes.Equal(decrypted, data) {
+			t.Errorf("Decrypt() = %d bytes, want %d bytes", len(decrypted), len(data))
+		}
+	}
+
+	// the random nonce makes every encryption of the same data different
+	first, _ := key.Encrypt([]byte("export"))
+	second, _ := key.Encrypt([]byte("export"))
+
+	if bytes.Equal(first, second) {
+		t.Error("Encrypt() returned the same output twice")
+	}
+}
+
+func TestKeyDecryptTampered(t *testing.T) {
+	key := newTestKey(t)
+
+	encrypted, err := key.Encrypt([]byte("/system identity\nset name=router1\n"))
+	if err != nil {
+		t.Fatalf("Encrypt() error: %v", err)
+	}
+
+	tamper := func(i int) []byte {
+		data := bytes.Clone(encrypted)
+		data[i] ^= 0x01
+
+		return data
+	}
+
+	tests := []struct {
+		name    string
+		key     *Key
+		data    []byte
+		wantErr error
+	}{
+		{name: "header version", key: key, data: tamper(len(magic) - 1), wantErr: ErrNotEncrypted},
+		{name: "nonce", key: key, data: tamper(len(magic))},
+		{name: "ciphertext", key: key, data: tamper(len(magic) + 12)},
+		{name: "tag", key: key, data: tamper(len(encrypted) - 1)},
+		{name: "truncated", key: key, data: encrypted[:len(encrypted)-1]},
+		{name: "appended", key: key, data: append(bytes.Clone(encrypted), 0)},
+		{name: "header only", key: key, data: magic, wantErr: ErrNotEncrypted},
+		{name: "plain data", key: key, data: []byte("/system identity\n"), wantErr: ErrNotEncrypted},
+		{name: "wrong key", key: newTestKey(t), data: encrypted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := tt.key.Decrypt(tt.data)
+			if err == nil {
+				t.Fatalf("Decrypt() = %q, want error", out)
+			}
+
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Decrypt() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package gitstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Repo is a local git repository where router exports are committed
+type Repo struct {
+	dir string
+	mut *sync.Mutex
+
+	initialized bool
+}
+
+// New returns the repository in dir. It is initialized on the first commit if it does not exist.
+func New(dir string) *Repo {
+	return &Repo{
+		dir: dir,
+		mut: &sync.Mutex{},
+	}
+}
+
+// Dir returns the repository directory
+func (r *Repo) Dir() string {
+	return r.dir
+}
+
+// Commit writes the content to the file path relative to the repository root and commits it.
+// It returns the commit hash, or an empty string if the content did not change.
+func (r *Repo) Commit(ctx context.Context, file string, content []byte, message string) (string, error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	if err := r.init(ctx); err != nil {
+		return "", err
+	}
+
+	fullPath := filepath.Join(r.dir, file)
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return "", fmt.Errorf("could not create dir: %w", err)
+	}
+
+	if err := os.WriteFile(fullPath, content, 0644); err != nil {
+		return "", fmt.Errorf("could not write file: %w", err)
+	}
+
+	if _, err := r.git(ctx, "add", "--", file); err != nil {
+		return "", err
+	}
+
+	// exit code 1 means there are staged changes
+	_, err := r.git(ctx, "diff", "--cached", "--quiet", "--", file)
+	if err == nil {
+		return "", nil
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+		return "", err
+	}
+
+	if _, err = r.git(ctx, "commit", "--quiet", "-m", message, "--", file); err != nil {
+		return "", err
+	}
+
+	hash, err := r.git(ctx, "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+
+	return hash, nil
+}
+
+// init creates the repository if it does not exist
+func (r *Repo) init(ctx context.Context) error {
+	if r.initialized {
+		return nil
+	}
+
+	if _, err := exec.LookPath("git"); err != nil {
+		return fmt.Errorf("git executable not found: %w", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(r.dir, ".git")); os.IsNotExist(err) {
+		if err = os.MkdirAll(r.dir, 0755); err != nil {
+			return fmt.Errorf("could not create git repository dir: %w", err)
+		}
+
+		if _, err = r.git(ctx, "init", "--quiet"); err != nil {
+			return err
+		}
+	}
+
+	r.initialized = true
+
+	return nil
+}
+
+// git runs the git command in the repository and returns its trimmed output.
+// The committer is set explicitly, so the commit does not depend on the user git config.
+func (r *Repo) git(ctx context.Context, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, "git", append([]string{
+		"-c", "user.name=gombak",
+		"-c", "user.email=gombak@localhost",
+	}, args...)...)
+	cmd.Dir = r.dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", fmt.Errorf("git %s: %w: %s", args[0], exitErr, strings.TrimSpace(stderr.String()))
+		}
+
+		return "", fmt.Errorf("could not run git %s: %w", args[0], err)
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
@@ -0,0 +1,139 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/ZeljkoBenovic/gombak/pkg/storage"
+)
+
+// Dir is the storage subfolder where run reports are stored
+const Dir = "runs"
+
+// Run holds the results of a single backup run
+type Run struct {
+	ID       string    `json:"id"`
+	Mode     string    `json:"mode"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Routers  []Router  `json:"routers"`
+
+	mut *sync.Mutex
+}
+
+// ErrorInsufficientSpace is the error type of the routers skipped because their storage is full
+const ErrorInsufficientSpace = "insufficient_space"
+
+// Router holds the backup result of a single router
+type Router struct {
+	Host     string `json:"host"`
+	Identity string `json:"identity,omitempty"`
+	// ID is the router unique id: the serial number, or the ip address if the serial number is not available
+	ID      string `json:"id,omitempty"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+	// ErrorType is set for the known errors, such as ErrorInsufficientSpace
+	ErrorType string     `json:"error_type,omitempty"`
+	Artifacts []Artifact `json:"artifacts,omitempty"`
+}
+
+// Artifact is a file stored as a part of the router backup
+type Artifact struct {
+	Name      string `json:"name"`
+	Path      string `json:"path,omitempty"`
+	Encrypted bool   `json:"encrypted"`
+	// Size is the artifact size before it was compressed and encrypted
+	Size int64 `json:"size,omitempty"`
+	// Changed is set for exports, if they were compared with the last stored export
+	Changed *bool `json:"changed,omitempty"`
+	// Commit is the git commit hash, if the export was committed to a git repository
+	Commit string `json:"commit,omitempty"`
+	// Error is set for the extra artifacts which could not be backed up, which have no path
+	Error string `json:"error,omitempty"`
+}
+
+// New starts a new run with a unique id
+func New(mode string) *Run {
+	started := time.Now()
+
+	return &Run{
+		ID:      newID(started),
+		Mode:    mode,
+		Started: started,
+		mut:     &sync.Mutex{},
+	}
+}
+
+func newID(t time.Time) string {
+	b := make([]byte, 3)
+	_, _ = rand.Read(b)
+
+	return fmt.Sprintf("%s-%s", t.Format("20060102T150405"), hex.EncodeToString(b))
+}
+
+// Add adds the router result to the run
+func (r *Run) Add(router Router) {
+	r.mut.Lock()
+	r.Routers = append(r.Routers, router)
+	r.mut.Unlock()
+}
+
+// Failed returns the number of routers which backup failed
+func (r *Run) Failed() int {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	failed := 0
+
+	for _, rt := range r.Routers {
+		if !rt.Success {
+			failed++
+		}
+	}
+
+	return failed
+}
+
+// FailedRouters returns the results of the routers which backup failed
+func (r *Run) FailedRouters() []Router {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	var failed []Router
+
+	for _, rt := range r.Routers {
+		if !rt.Success {
+			failed = append(failed, rt)
+		}
+	}
+
+	return failed
+}
+
+// Finish marks the run as finished and stores the report as a json file in the Dir subfolder of the storage.
+// It returns the report location.
+func (r *Run) Finish(ctx context.Context, store storage.Storage) (string, error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.Finished = time.Now()
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("could not marshal run report: %w", err)
+	}
+
+	name := path.Join(Dir, r.ID+".json")
+	if err = store.Put(ctx, name, bytes.NewReader(data)); err != nil {
+		return "", fmt.Errorf("could not store run report: %w", err)
+	}
+
+	return store.Location(name), nil
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -19,9 +20,10 @@ type Service struct {
 }
 
 type serviceRunner struct {
-	runFn  func() error
+	runFn  func(ctx context.Context) error
 	log    srv.Logger
-	stopCh chan struct{}
+	cancel context.CancelFunc
+	doneCh chan struct{}
 	conf   config.Config
 }
 
@@ -29,7 +31,7 @@ func New(conf config.Config, args []string, log *logger.Logger) (*Service, error
 	s := &Service{
 		log: log,
 		runner: &serviceRunner{
-			stopCh: make(chan struct{}),
+			doneCh: make(chan struct{}),
 			conf:   conf,
 		},
 	}
@@ -57,7 +59,7 @@ func New(conf config.Config, args []string, log *logger.Logger) (*Service, error
 
 // HandleServiceCLICommands will handle "install", "uninstall" and "run" cli commands which handle gombak as a system service.
 // If these cli arguments are not set, this method returns false signaling that it should be run as a console program.
-func (s *Service) HandleServiceCLICommands(runFn func() error) (err error, isService bool) {
+func (s *Service) HandleServiceCLICommands(runFn func(ctx context.Context) error) (err error, isService bool) {
 	isService = true
 	err = nil
 
@@ -93,7 +95,12 @@ func (s *serviceRunner) Start(_ srv.Service) error {
 		return fmt.Errorf("runFn function not initialized")
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
 	go func() {
+		defer close(s.doneCh)
+
 		ticker := time.NewTicker(time.Hour * 24 * time.Duration(s.conf.BackupFrequencyDays))
 		defer ticker.Stop()
 
@@ -101,12 +108,12 @@ func (s *serviceRunner) Start(_ srv.Service) error {
 			select {
 			case <-ticker.C:
 				_ = s.log.Info("running mikrotik backup per schedule")
-				if err := s.runFn(); err != nil {
+				if err := s.runFn(ctx); err != nil {
 					if err := s.log.Error(err); err != nil {
 						log.Println(err)
 					}
 				}
-			case <-s.stopCh:
+			case <-ctx.Done():
 				_ = s.log.Info("stopping gombak service")
 
 				return
@@ -117,7 +124,10 @@ func (s *serviceRunner) Start(_ srv.Service) error {
 	return nil
 }
 
+// Stop cancels the backup run in progress and waits for it to abort
 func (s *serviceRunner) Stop(_ srv.Service) error {
-	s.stopCh <- struct{}{}
+	s.cancel()
+	<-s.doneCh
+
 	return nil
 }
@@ -0,0 +1,233 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+type HostKeyMode string
+
+const (
+	// HostKeyStrict accepts only the host keys already present in the known hosts file
+	HostKeyStrict HostKeyMode = "strict"
+	// HostKeyTOFU records the host key on first contact and rejects any later mismatch
+	HostKeyTOFU HostKeyMode = "tofu"
+	// HostKeyIgnore accepts any host key
+	HostKeyIgnore HostKeyMode = "ignore"
+)
+
+var HostKeyModes = map[string]HostKeyMode{
+	"strict": HostKeyStrict,
+	"tofu":   HostKeyTOFU,
+	"ignore": HostKeyIgnore,
+}
+
+// HostKeyMismatchError is returned when the host presents a key different from the one recorded in the known hosts file
+type HostKeyMismatchError struct {
+	Host string
+	Want []string
+	Got  string
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	return fmt.Sprintf(
+		"host key mismatch for %s: got %s, want %s - possible man-in-the-middle attack",
+		e.Host, e.Got, strings.Join(e.Want, ", "),
+	)
+}
+
+// UnknownHostKeyError is returned in strict mode when the host is not found in the known hosts file
+type UnknownHostKeyError struct {
+	Host string
+	Got  string
+}
+
+func (e *UnknownHostKeyError) Error() string {
+	return fmt.Sprintf("host key %s for %s not found in known hosts file", e.Got, e.Host)
+}
+
+// HostKeyStore verifies host keys against an OpenSSH compatible known hosts file
+type HostKeyStore struct {
+	file string
+	mode HostKeyMode
+	mut  *sync.Mutex
+}
+
+// DefaultKnownHostsFile returns the path of the gombak managed known hosts file
+func DefaultKnownHostsFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = "."
+	}
+
+	return filepath.Join(dir, "gombak", "known_hosts")
+}
+
+// NewHostKeyStore returns a host key store backed by the known hosts file.
+// The file is created on the first recorded key if it does not exist.
+func NewHostKeyStore(file string, mode HostKeyMode) *HostKeyStore {
+	if file == "" {
+		file = DefaultKnownHostsFile()
+	}
+
+	return &HostKeyStore{
+		file: file,
+		mode: mode,
+		mut:  &sync.Mutex{},
+	}
+}
+
+// hostKeyAlgorithms are the supported host key algorithms in order of preference, with the key type each one uses
+var hostKeyAlgorithms = []struct {
+	algorithm string
+	keyType   string
+}{
+	{ssh.KeyAlgoED25519, ssh.KeyAlgoED25519},
+	{ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA256},
+	{ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA384},
+	{ssh.KeyAlgoECDSA521, ssh.KeyAlgoECDSA521},
+	{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSA},
+	{ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA},
+	{ssh.KeyAlgoRSA, ssh.KeyAlgoRSA},
+}
+
+// WithHostKeyStore verifies the host key using the provided store.
+// The host key algorithms are limited to the types of the keys recorded for the host,
+// so that a host with more than one key presents the recorded one.
+func WithHostKeyStore(store *HostKeyStore) ClientOpts {
+	return func(c *clientConfig) {
+		c.HostKeyCallback = store.check
+		c.hostKeyAlgorithms = store.algorithms
+	}
+}
+
+// algorithms returns the host key algorithms matching the key types recorded for the address,
+// or nil if there are none, in which case the default algorithms are used
+func (h *HostKeyStore) algorithms(address string) []string {
+	if h.mode == HostKeyIgnore {
+		return nil
+	}
+
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	cb, err := h.callback()
+	if err != nil {
+		return nil
+	}
+
+	// the probe key is never recorded, so the error lists all the keys recorded for the address
+	probe, err := ssh.NewPublicKey(ed25519.PublicKey(make([]byte, ed25519.PublicKeySize)))
+	if err != nil {
+		return nil
+	}
+
+	var keyErr *knownhosts.KeyError
+	if !errors.As(cb(address, &net.TCPAddr{}, probe), &keyErr) {
+		return nil
+	}
+
+	recorded := make(map[string]bool)
+	for _, k := range keyErr.Want {
+		recorded[k.Key.Type()] = true
+	}
+
+	var algorithms []string
+
+	for _, a := range hostKeyAlgorithms {
+		if recorded[a.keyType] {
+			algorithms = append(algorithms, a.algorithm)
+		}
+	}
+
+	return algorithms
+}
+
+func (h *HostKeyStore) check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if h.mode == HostKeyIgnore {
+		return nil
+	}
+
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	// the file is read on every check so that keys recorded by other connections are taken into account
+	cb, err := h.callback()
+	if err != nil {
+		return err
+	}
+
+	err = cb(hostname, remote, key)
+	if err == nil {
+		return nil
+	}
+
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) {
+		return err
+	}
+
+	if len(keyErr.Want) > 0 {
+		want := make([]string, 0, len(keyErr.Want))
+		for _, k := range keyErr.Want {
+			want = append(want, fmt.Sprintf("%s (%s:%d)", ssh.FingerprintSHA256(k.Key), k.Filename, k.Line))
+		}
+
+		return &HostKeyMismatchError{
+			Host: hostname,
+			Want: want,
+			Got:  ssh.FingerprintSHA256(key),
+		}
+	}
+
+	if h.mode == HostKeyStrict {
+		return &UnknownHostKeyError{
+			Host: hostname,
+			Got:  ssh.FingerprintSHA256(key),
+		}
+	}
+
+	return h.add(hostname, key)
+}
+
+func (h *HostKeyStore) callback() (ssh.HostKeyCallback, error) {
+	if _, err := os.Stat(h.file); os.IsNotExist(err) {
+		return func(string, net.Addr, ssh.PublicKey) error {
+			return &knownhosts.KeyError{}
+		}, nil
+	}
+
+	cb, err := knownhosts.New(h.file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read known hosts file: %w", err)
+	}
+
+	return cb, nil
+}
+
+func (h *HostKeyStore) add(hostname string, key ssh.PublicKey) error {
+	if err := os.MkdirAll(filepath.Dir(h.file), 0700); err != nil {
+		return fmt.Errorf("could not create known hosts dir: %w", err)
+	}
+
+	f, err := os.OpenFile(h.file, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("could not open known hosts file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err = fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)); err != nil {
+		return fmt.Errorf("could not record host key: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package ssh
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func TestHostKeyStoreCheck(t *testing.T) {
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+	recorded := newTestKey(t)
+	other := newTestKey(t)
+
+	tests := []struct {
+		name string
+		mode HostKeyMode
+		// known records the key for the host before the check
+		known bool
+		key   ssh.PublicKey
+		// wantErr is a pointer to the expected error type, or nil if the check passes
+		wantErr any
+		// wantRecorded reports whether the key is in the known hosts file after the check
+		wantRecorded bool
+	}{
+		{name: "tofu records the first key", mode: HostKeyTOFU, key: recorded, wantRecorded: true},
+		{name: "tofu accepts the recorded key", mode: HostKeyTOFU, known: true, key: recorded, wantRecorded: true},
+		{name: "tofu rejects a different key", mode: HostKeyTOFU, known: true, key: other, wantErr: new(*HostKeyMismatchError)},
+		{name: "strict rejects an unknown host", mode: HostKeyStrict, key: recorded, wantErr: new(*UnknownHostKeyError)},
+		{name: "strict accepts the recorded key", mode: HostKeyStrict, known: true, key: recorded, wantRecorded: true},
+		{name: "strict rejects a different key", mode: HostKeyStrict, known: true, key: other, wantErr: new(*HostKeyMismatchError)},
+		{name: "ignore accepts any key", mode: HostKeyIgnore, known: true, key: other},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the known hosts dir is created on the first recorded key
+			file := filepath.Join(t.TempDir(), "gombak", "known_hosts")
+
+			if tt.known {
+				if err := NewHostKeyStore(file, HostKeyTOFU).add("10.0.0.1:22", recorded); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := NewHostKeyStore(file, tt.mode).check("10.0.0.1:22", remote, tt.key)
+
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Fatalf("check() error: %v", err)
+			case tt.wantErr != nil && !errors.As(err, tt.wantErr):
+				t.Fatalf("check() error = %v, want %T", err, reflect.ValueOf(tt.wantErr).Elem().Interface())
+			}
+
+			content, err := os.ReadFile(file)
+			if err != nil && !os.IsNotExist(err) {
+				t.Fatal(err)
+			}
+
+			line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(tt.key)))
+			if got := strings.Contains(string(content), line); got != tt.wantRecorded {
+				t.Errorf("key recorded = %v, want %v, known hosts:\n%s", got, tt.wantRecorded, content)
+			}
+		})
+	}
+}
+
+func TestHostKeyStoreMismatchError(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	recorded := newTestKey(t)
+	other := newTestKey(t)
+
+	store := NewHostKeyStore(file, HostKeyTOFU)
+
+	if err := store.check("router1:22", &net.TCPAddr{}, recorded); err != nil {
+		t.Fatalf("check() error: %v", err)
+	}
+
+	var mismatch *HostKeyMismatchError
+	if err := store.check("router1:22", &net.TCPAddr{}, other); !errors.As(err, &mismatch) {
+		t.Fatalf("check() error = %v, want host key mismatch", err)
+	}
+
+	if mismatch.Got != ssh.FingerprintSHA256(other) {
+		t.Errorf("mismatch got = %s, want %s", mismatch.Got, ssh.FingerprintSHA256(other))
+	}
+
+	if len(mismatch.Want) != 1 || !strings.HasPrefix(mismatch.Want[0], ssh.FingerprintSHA256(recorded)+" ("+file+":1)") {
+		t.Errorf("mismatch want = %v, want the recorded key at %s:1", mismatch.Want, file)
+	}
+
+	// the mismatched key is not recorded
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lines := strings.Count(string(content), "\n"); lines != 1 {
+		t.Errorf("known hosts has %d lines after the mismatch, want 1", lines)
+	}
+}
+
+func TestHostKeyStoreAlgorithms(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	store := NewHostKeyStore(file, HostKeyTOFU)
+
+	if got := store.algorithms("router1:22"); got != nil {
+		t.Errorf("algorithms() without known hosts = %v, want nil", got)
+	}
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecdsaKey, err := ssh.NewPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []ssh.PublicKey{ecdsaKey, newTestKey(t)} {
+		if err := store.add("router1:22", key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{ssh.KeyAlgoED25519, ssh.KeyAlgoECDSA256}
+	if got := store.algorithms("router1:22"); !reflect.DeepEqual(got, want) {
+		t.Errorf("algorithms() = %v, want %v", got, want)
+	}
+
+	if got := store.algorithms("router2:22"); got != nil {
+		t.Errorf("algorithms() of an unknown host = %v, want nil", got)
+	}
+
+	if got := NewHostKeyStore(file, HostKeyIgnore).algorithms("router1:22"); got != nil {
+		t.Errorf("algorithms() in ignore mode = %v, want nil", got)
+	}
+}
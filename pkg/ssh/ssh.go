@@ -1,59 +1,301 @@
 package ssh
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
-	"path"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 )
 
+var ErrAgentSocketNotFound = errors.New("ssh agent socket not set and SSH_AUTH_SOCK is empty")
+
+// ErrSessionClosed is returned when the connection is closed before the command exits, for example when the router reboots
+var ErrSessionClosed = errors.New("ssh session closed before the command exited")
+
+// IncompleteDownloadError is returned when the downloaded file size does not match the remote file size
+type IncompleteDownloadError struct {
+	File string
+	Want int64
+	Got  int64
+}
+
+func (e *IncompleteDownloadError) Error() string {
+	return fmt.Sprintf("incomplete download of %s: got %d bytes, want %d bytes", e.File, e.Got, e.Want)
+}
+
+// IncompleteUploadError is returned when the uploaded file size does not match the source size
+type IncompleteUploadError struct {
+	File string
+	Want int64
+	Got  int64
+}
+
+func (e *IncompleteUploadError) Error() string {
+	return fmt.Sprintf("incomplete upload of %s: wrote %d bytes, want %d bytes", e.File, e.Got, e.Want)
+}
+
 type SSH struct {
 	cl *ssh.Client
+	// jumps holds the connections to the jump hosts, in the order they were dialed
+	jumps []*ssh.Client
+
+	// sftp session is opened on first use and shared by all file operations
+	sftp     *sftp.Client
+	sftpSess *ssh.Session
+	sftpMut  *sync.Mutex
 }
 
-type ClientOpts func(config *ssh.ClientConfig)
+// JumpHost is an ssh server used as a hop to reach the target host
+type JumpHost struct {
+	User string
+	Host string
+	Port string
+	// Opts are the client options, such as auth method and host key verification, used for this jump host
+	Opts []ClientOpts
+}
+
+// clientConfig wraps ssh.ClientConfig with the state client options need while the connection is being set up
+type clientConfig struct {
+	*ssh.ClientConfig
+
+	// closers are closed once the ssh handshake is done
+	closers []io.Closer
+	// jumpHosts are dialed in order before the target host
+	jumpHosts []JumpHost
+	// err holds the first error returned by a client option
+	err error
+	// hostKeyAlgorithms returns the host key algorithms accepted from the host address, if set
+	hostKeyAlgorithms func(address string) []string
+}
+
+func (c *clientConfig) setErr(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+func (c *clientConfig) close() {
+	for _, cl := range c.closers {
+		_ = cl.Close()
+	}
+
+	c.closers = nil
+}
+
+type ClientOpts func(config *clientConfig)
 
 func WithInsecureKeyExchange() ClientOpts {
-	return func(c *ssh.ClientConfig) {
+	return func(c *clientConfig) {
 		c.KeyExchanges = append(c.KeyExchanges, "diffie-hellman-group-exchange-sha256")
 	}
 }
 
 func WithIgnoreHostKey() ClientOpts {
-	return func(c *ssh.ClientConfig) {
+	return func(c *clientConfig) {
 		c.HostKeyCallback = ssh.InsecureIgnoreHostKey()
 	}
 }
 
 func WithPassword(pass string) ClientOpts {
-	return func(c *ssh.ClientConfig) {
-		c.Auth = []ssh.AuthMethod{ssh.Password(pass)}
+	return func(c *clientConfig) {
+		c.Auth = append(c.Auth, ssh.Password(pass))
 	}
 }
 
-func NewSSH(user, host, port string, opts ...ClientOpts) (*SSH, error) {
-	sshConf := &ssh.ClientConfig{}
-	sshConf.SetDefaults()
-	sshConf.User = user
+// WithPrivateKeyFile authenticates using the private key found in keyFile.
+// The passphrase is used only if the key is encrypted.
+func WithPrivateKeyFile(keyFile, passphrase string) ClientOpts {
+	return func(c *clientConfig) {
+		key, err := os.ReadFile(keyFile)
+		if err != nil {
+			c.setErr(fmt.Errorf("could not read private key file: %w", err))
+			return
+		}
+
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			var passErr *ssh.PassphraseMissingError
+			if !errors.As(err, &passErr) {
+				c.setErr(fmt.Errorf("could not parse private key: %w", err))
+				return
+			}
+
+			if passphrase == "" {
+				c.setErr(fmt.Errorf("private key %s is encrypted but no passphrase was provided", keyFile))
+				return
+			}
+
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+			if err != nil {
+				c.setErr(fmt.Errorf("could not parse encrypted private key: %w", err))
+				return
+			}
+		}
+
+		c.Auth = append(c.Auth, ssh.PublicKeys(signer))
+	}
+}
+
+// WithAgent authenticates using the keys held by the ssh agent listening on socket.
+// If socket is empty, the SSH_AUTH_SOCK environment variable is used.
+func WithAgent(socket string) ClientOpts {
+	return func(c *clientConfig) {
+		if socket == "" {
+			socket = os.Getenv("SSH_AUTH_SOCK")
+		}
+
+		if socket == "" {
+			c.setErr(ErrAgentSocketNotFound)
+			return
+		}
+
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			c.setErr(fmt.Errorf("could not connect to ssh agent: %w", err))
+			return
+		}
+
+		c.closers = append(c.closers, conn)
+		c.Auth = append(c.Auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+	}
+}
+
+// WithConnectTimeout limits the time spent on establishing the connection and the ssh handshake with each host
+func WithConnectTimeout(timeout time.Duration) ClientOpts {
+	return func(c *clientConfig) {
+		c.Timeout = timeout
+	}
+}
+
+// WithJumpHosts tunnels the connection through the jump hosts, in the order they are provided
+func WithJumpHosts(hosts ...JumpHost) ClientOpts {
+	return func(c *clientConfig) {
+		c.jumpHosts = append(c.jumpHosts, hosts...)
+	}
+}
+
+func newClientConfig(user string, opts ...ClientOpts) *clientConfig {
+	conf := &clientConfig{ClientConfig: &ssh.ClientConfig{}}
+	conf.SetDefaults()
+	conf.User = user
 
 	for _, f := range opts {
-		f(sshConf)
+		f(conf)
+	}
+
+	return conf
+}
+
+// NewSSH connects to the host, through the jump hosts if they are set.
+// The context aborts the connection attempt, while WithConnectTimeout limits the time spent on each hop.
+func NewSSH(ctx context.Context, user, host, port string, opts ...ClientOpts) (*SSH, error) {
+	sshConf := newClientConfig(user, opts...)
+
+	// agent connections are only needed during authentication
+	defer sshConf.close()
+
+	if sshConf.err != nil {
+		return nil, sshConf.err
 	}
 
-	cl, err := ssh.Dial("tcp", net.JoinHostPort(host, port), sshConf)
+	s := &SSH{
+		sftpMut: &sync.Mutex{},
+	}
+
+	for _, j := range sshConf.jumpHosts {
+		jumpConf := newClientConfig(j.User, j.Opts...)
+
+		jump, err := s.dial(ctx, net.JoinHostPort(j.Host, j.Port), jumpConf)
+		if err != nil {
+			_ = s.Close()
+			return nil, fmt.Errorf("could not connect to jump host %s: %w", j.Host, err)
+		}
+
+		s.jumps = append(s.jumps, jump)
+	}
+
+	cl, err := s.dial(ctx, net.JoinHostPort(host, port), sshConf)
 	if err != nil {
+		_ = s.Close()
 		return nil, fmt.Errorf("could not create new ssh client: %w", err)
 	}
 
-	return &SSH{
-		cl: cl,
-	}, nil
+	s.cl = cl
+
+	return s, nil
+}
+
+// dial connects to addr through the last connected jump host, or directly if there are none
+func (s *SSH) dial(ctx context.Context, addr string, conf *clientConfig) (*ssh.Client, error) {
+	defer conf.close()
+
+	if conf.err != nil {
+		return nil, conf.err
+	}
+
+	if conf.hostKeyAlgorithms != nil && len(conf.HostKeyAlgorithms) == 0 {
+		conf.HostKeyAlgorithms = conf.hostKeyAlgorithms(addr)
+	}
+
+	if conf.Timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, conf.Timeout)
+		defer cancel()
+	}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+
+	if len(s.jumps) == 0 {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("could not dial %s: %w", addr, err)
+		}
+	} else {
+		conn, err = s.jumps[len(s.jumps)-1].DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("could not dial %s through jump host: %w", addr, err)
+		}
+	}
+
+	// closing the connection aborts the handshake
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+	})
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, conf.ClientConfig)
+	if !stop() {
+		if err == nil {
+			_ = c.Close()
+		}
+
+		return nil, fmt.Errorf("ssh handshake with %s aborted: %w", addr, ctx.Err())
+	}
+
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return ssh.NewClient(c, chans, reqs), nil
 }
 
-func (s *SSH) Run(cmd string) (string, error) {
+// Run runs the command and returns its combined output.
+// If the context is done before the command completes, the session is closed and the context error is returned.
+func (s *SSH) Run(ctx context.Context, cmd string) (string, error) {
 	sess, err := s.cl.NewSession()
 	if err != nil {
 		return "", fmt.Errorf("could not create new ssh session: %w", err)
@@ -61,7 +303,21 @@ func (s *SSH) Run(cmd string) (string, error) {
 
 	defer sess.Close()
 
+	stop := context.AfterFunc(ctx, func() {
+		_ = sess.Close()
+	})
+	defer stop()
+
 	byteOut, err := sess.CombinedOutput(cmd)
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("command aborted: %w", ctx.Err())
+	}
+
+	var exitMissing *ssh.ExitMissingError
+	if errors.As(err, &exitMissing) {
+		return string(byteOut), fmt.Errorf("%w: %w", ErrSessionClosed, err)
+	}
+
 	if err != nil {
 		return "", err
 	}
@@ -69,48 +325,308 @@ func (s *SSH) Run(cmd string) (string, error) {
 	return string(byteOut), nil
 }
 
+// SFTP returns the sftp session shared by all file operations, opening it if needed
+func (s *SSH) SFTP() (*sftp.Client, error) {
+	s.sftpMut.Lock()
+	defer s.sftpMut.Unlock()
+
+	if s.sftp != nil {
+		return s.sftp, nil
+	}
+
+	// the sftp client runs on its own session, so that pending requests can be aborted by closing the session
+	sess, err := s.cl.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("could not create new ssh session: %w", err)
+	}
+
+	cl, err := newSFTPClient(sess)
+	if err != nil {
+		_ = sess.Close()
+		return nil, fmt.Errorf("could not create new sftp client: %w", err)
+	}
+
+	s.sftp, s.sftpSess = cl, sess
+
+	return cl, nil
+}
+
+func newSFTPClient(sess *ssh.Session) (*sftp.Client, error) {
+	if err := sess.RequestSubsystem("sftp"); err != nil {
+		return nil, err
+	}
+
+	w, err := sess.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := sess.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	return sftp.NewClientPipe(r, w)
+}
+
+// closeSFTP closes the sftp session if it is still the shared one, so that it is opened again on the next use.
+// The session is closed before the client, as the client waits for the pending requests.
+func (s *SSH) closeSFTP(cl *sftp.Client) {
+	s.sftpMut.Lock()
+	defer s.sftpMut.Unlock()
+
+	if s.sftp == nil || s.sftp != cl {
+		return
+	}
+
+	_ = s.sftpSess.Close()
+	_ = s.sftp.Close()
+
+	s.sftp, s.sftpSess = nil, nil
+}
+
 func (s *SSH) Close() error {
-	return s.cl.Close()
+	var err error
+
+	s.sftpMut.Lock()
+	if s.sftp != nil {
+		_ = s.sftpSess.Close()
+		_ = s.sftp.Close()
+		s.sftp, s.sftpSess = nil, nil
+	}
+	s.sftpMut.Unlock()
+
+	if s.cl != nil {
+		err = s.cl.Close()
+	}
+
+	for i := len(s.jumps) - 1; i >= 0; i-- {
+		_ = s.jumps[i].Close()
+	}
+
+	return err
 }
 
-func (s *SSH) Download(downloadFrom, downloadTo string) error {
-	cl, err := sftp.NewClient(s.cl)
+// RunTo runs the command and writes its standard output to w.
+// If the context is done before the command completes, the session is closed and the context error is returned.
+func (s *SSH) RunTo(ctx context.Context, cmd string, w io.Writer) error {
+	sess, err := s.cl.NewSession()
 	if err != nil {
-		return fmt.Errorf("could not create new sftp client: %w", err)
+		return fmt.Errorf("could not create new ssh session: %w", err)
 	}
-	defer cl.Close()
 
-	_, err = os.Stat(path.Dir(downloadTo))
-	if os.IsNotExist(err) {
-		if err = os.Mkdir(path.Dir(downloadTo), 0666); err != nil {
-			return fmt.Errorf("could not create backup dir: %w", err)
-		}
+	defer sess.Close()
+
+	stop := context.AfterFunc(ctx, func() {
+		_ = sess.Close()
+	})
+	defer stop()
+
+	var stderr bytes.Buffer
+
+	sess.Stdout = w
+	sess.Stderr = &stderr
+
+	err = sess.Run(cmd)
+	if ctx.Err() != nil {
+		return fmt.Errorf("command aborted: %w", ctx.Err())
 	}
 
-	local, err := os.Create(downloadTo)
 	if err != nil {
-		return fmt.Errorf("could not create new file: %w", err)
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
 	}
-	defer local.Close()
+
+	return nil
+}
+
+// Download copies the remote file to w, and checks that the copied size matches the remote file size.
+// If the context is done before the transfer completes, the transfer is aborted and the context error is returned.
+func (s *SSH) Download(ctx context.Context, downloadFrom string, w io.Writer) error {
+	cl, err := s.SFTP()
+	if err != nil {
+		return err
+	}
+
+	stop := s.abortOnDone(ctx, cl)
+	defer stop()
 
 	remote, err := cl.Open(downloadFrom)
+	if ctx.Err() != nil {
+		return fmt.Errorf("download aborted: %w", ctx.Err())
+	}
+
 	if err != nil {
 		return fmt.Errorf("could not open remote file: %w", err)
 	}
 	defer remote.Close()
 
-	_, err = remote.WriteTo(local)
+	remoteInfo, err := remote.Stat()
+	if ctx.Err() != nil {
+		return fmt.Errorf("download aborted: %w", ctx.Err())
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not stat remote file: %w", err)
+	}
+
+	n, err := remote.WriteTo(w)
+	if ctx.Err() != nil {
+		return fmt.Errorf("download aborted: %w", ctx.Err())
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not download file: %w", err)
+	}
+
+	if n != remoteInfo.Size() {
+		return &IncompleteDownloadError{
+			File: downloadFrom,
+			Want: remoteInfo.Size(),
+			Got:  n,
+		}
+	}
+
+	return nil
+}
+
+// abortOnDone closes the sftp session when the context is done, so that the pending sftp requests return.
+// The session is opened again on the next use. The returned function stops the abort.
+func (s *SSH) abortOnDone(ctx context.Context, cl *sftp.Client) func() bool {
+	return context.AfterFunc(ctx, func() {
+		s.closeSFTP(cl)
+	})
+}
+
+// Delete removes the remote file.
+// If the context is done before the file is removed, the request is aborted and the context error is returned.
+func (s *SSH) Delete(ctx context.Context, fileName string) error {
+	cl, err := s.SFTP()
+	if err != nil {
+		return err
+	}
+
+	stop := s.abortOnDone(ctx, cl)
+	defer stop()
+
+	err = cl.Remove(fileName)
+	if ctx.Err() != nil {
+		return fmt.Errorf("delete aborted: %w", ctx.Err())
+	}
 
 	return err
 }
 
-func (s *SSH) Delete(fileName string) error {
-	cl, err := sftp.NewClient(s.cl)
+// List returns the files found in the remote directory.
+// If the context is done before the directory is listed, the request is aborted and the context error is returned.
+func (s *SSH) List(ctx context.Context, dir string) ([]os.FileInfo, error) {
+	cl, err := s.SFTP()
+	if err != nil {
+		return nil, err
+	}
+
+	stop := s.abortOnDone(ctx, cl)
+	defer stop()
+
+	files, err := cl.ReadDir(dir)
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("list aborted: %w", ctx.Err())
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not list remote dir: %w", err)
+	}
+
+	return files, nil
+}
+
+// Walk calls fn for each file and directory in the remote tree, starting with root.
+// If the context is done before the walk completes, the walk is aborted and the context error is returned.
+func (s *SSH) Walk(ctx context.Context, root string, fn func(path string, info os.FileInfo) error) error {
+	cl, err := s.SFTP()
+	if err != nil {
+		return err
+	}
+
+	stop := s.abortOnDone(ctx, cl)
+	defer stop()
+
+	walker := cl.Walk(root)
+
+	for walker.Step() {
+		if ctx.Err() != nil {
+			return fmt.Errorf("walk aborted: %w", ctx.Err())
+		}
+
+		if err = walker.Err(); err != nil {
+			return fmt.Errorf("could not list remote dir: %w", err)
+		}
+
+		if err = fn(walker.Path(), walker.Stat()); err != nil {
+			return err
+		}
+	}
+
+	if ctx.Err() != nil {
+		return fmt.Errorf("walk aborted: %w", ctx.Err())
+	}
+
+	return nil
+}
+
+// Upload copies r to the remote path, replacing the remote file if it exists.
+// If the context is done before the transfer completes, the transfer is aborted and the context error is returned.
+// If the size of r is known, an IncompleteUploadError is returned when fewer bytes are written.
+func (s *SSH) Upload(ctx context.Context, r io.Reader, uploadTo string) error {
+	cl, err := s.SFTP()
+	if err != nil {
+		return err
+	}
+
+	// the size is known for in-memory readers and files
+	size := int64(-1)
+
+	switch v := r.(type) {
+	case interface{ Len() int }:
+		size = int64(v.Len())
+	case *os.File:
+		if info, err := v.Stat(); err == nil {
+			size = info.Size()
+		}
+	}
+
+	stop := s.abortOnDone(ctx, cl)
+	defer stop()
+
+	remote, err := cl.Create(uploadTo)
+	if ctx.Err() != nil {
+		return fmt.Errorf("upload aborted: %w", ctx.Err())
+	}
+
 	if err != nil {
-		return fmt.Errorf("could not create new sftp client: %w", err)
+		return fmt.Errorf("could not create remote file: %w", err)
 	}
+	defer remote.Close()
+
+	n, err := remote.ReadFrom(r)
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("upload aborted: %w", ctx.Err())
+		}
 
-	defer cl.Close()
+		return fmt.Errorf("could not upload file: %w", err)
+	}
+
+	if err = remote.Close(); err != nil {
+		return fmt.Errorf("could not close remote file: %w", err)
+	}
+
+	if size >= 0 && n != size {
+		return &IncompleteUploadError{
+			File: uploadTo,
+			Want: size,
+			Got:  n,
+		}
+	}
 
-	return cl.Remove(fileName)
+	return nil
 }
@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// LocalDir stores backups in a local directory
+type LocalDir struct {
+	dir string
+}
+
+func NewLocalDir(dir string) *LocalDir {
+	return &LocalDir{
+		dir: dir,
+	}
+}
+
+func (l *LocalDir) path(name string) string {
+	return filepath.Join(l.dir, filepath.FromSlash(name))
+}
+
+// Put writes to a temporary file first, which is renamed to the object path only if the whole content is written
+func (l *LocalDir) Put(_ context.Context, name string, r io.Reader) error {
+	to := l.path(name)
+
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return fmt.Errorf("could not create backup dir: %w", err)
+	}
+
+	local, err := os.CreateTemp(filepath.Dir(to), "."+filepath.Base(to)+".*.part")
+	if err != nil {
+		return fmt.Errorf("could not create new file: %w", err)
+	}
+
+	// the temporary file is removed unless it was renamed to the final path
+	defer func() {
+		_ = local.Close()
+		_ = os.Remove(local.Name())
+	}()
+
+	if _, err = io.Copy(local, r); err != nil {
+		return fmt.Errorf("could not write file: %w", err)
+	}
+
+	if err = local.Sync(); err != nil {
+		return fmt.Errorf("could not sync file: %w", err)
+	}
+
+	if err = local.Close(); err != nil {
+		return fmt.Errorf("could not close file: %w", err)
+	}
+
+	if err = os.Rename(local.Name(), to); err != nil {
+		return fmt.Errorf("could not move file: %w", err)
+	}
+
+	return nil
+}
+
+func (l *LocalDir) Get(_ context.Context, name string) (io.ReadCloser, error) {
+	f, err := os.Open(l.path(name))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not open file: %w", err)
+	}
+
+	return f, nil
+}
+
+// List skips the git repositories and the temporary files of writes in progress
+func (l *LocalDir) List(_ context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+
+	err := filepath.WalkDir(l.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == l.dir {
+				return filepath.SkipDir
+			}
+
+			return err
+		}
+
+		if d.IsDir() {
+			// git repositories keep their own history
+			if _, err := os.Stat(filepath.Join(p, ".git")); err == nil {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(l.dir, p)
+		if err != nil {
+			return err
+		}
+
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		objects = append(objects, Object{
+			Name:    name,
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+		})
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list backup dir: %w", err)
+	}
+
+	return objects, nil
+}
+
+// Delete removes the file, and the directories left empty by its removal
+func (l *LocalDir) Delete(_ context.Context, name string) error {
+	if err := os.Remove(l.path(name)); err != nil {
+		return err
+	}
+
+	// removing a directory fails if it is not empty
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if os.Remove(l.path(dir)) != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (l *LocalDir) Touch(_ context.Context, name string) error {
+	now := time.Now()
+
+	return os.Chtimes(l.path(name), now, now)
+}
+
+func (l *LocalDir) Location(name string) string {
+	return l.path(name)
+}
+
+func (l *LocalDir) Close() error {
+	return nil
+}
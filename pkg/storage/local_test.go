@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestLocalDir(t *testing.T) {
+	dir := t.TempDir()
+	store := NewLocalDir(dir)
+
+	testRoundTrip(t, store, func(name string, modTime time.Time) {
+		if err := os.Chtimes(store.path(name), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	// the directories left empty by the deletes are removed
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 0 {
+		t.Errorf("backup dir after Delete() has %d entries, want none", len(entries))
+	}
+}
+
+func TestLocalDirListSkips(t *testing.T) {
+	dir := t.TempDir()
+
+	files := []string{
+		"router1-2024-01-01.rsc",
+		".router1-2024-01-02.rsc.123.part",
+		"git/.git/HEAD",
+		"git/router1.rsc",
+	}
+
+	for _, name := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(file, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	objects, err := NewLocalDir(dir).List(context.Background(), "")
+	if err != nil {
+		t.Fatalf("List() error: %v", err)
+	}
+
+	if len(objects) != 1 || objects[0].Name != "router1-2024-01-01.rsc" {
+		t.Errorf("List() = %+v, want only router1-2024-01-01.rsc", objects)
+	}
+}
+
+func TestLocalDirListMissingDir(t *testing.T) {
+	objects, err := NewLocalDir(filepath.Join(t.TempDir(), "missing")).List(context.Background(), "")
+	if err != nil || len(objects) != 0 {
+		t.Errorf("List() of a missing dir = %+v, %v, want no objects", objects, err)
+	}
+}
+
+func TestLocalDirPutFailed(t *testing.T) {
+	dir := t.TempDir()
+	store := NewLocalDir(dir)
+
+	if err := store.Put(context.Background(), "router1-2024-01-01.rsc", iotest.ErrReader(errors.New("read failed"))); err == nil {
+		t.Fatal("Put() of a failing reader error = nil, want error")
+	}
+
+	// neither the object nor the temporary file is left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 0 {
+		t.Errorf("backup dir after a failed Put() has %d entries, want none", len(entries))
+	}
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Config holds the S3 compatible object store settings
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	// Insecure uses plain http to connect to the endpoint
+	Insecure bool
+}
+
+// S3Bucket stores backups in an S3 compatible object store, such as AWS S3 or MinIO
+type S3Bucket struct {
+	cl     *minio.Client
+	bucket string
+	prefix string
+}
+
+func NewS3Bucket(conf S3Config) (*S3Bucket, error) {
+	if conf.Bucket == "" {
+		return nil, fmt.Errorf("s3 bucket not set")
+	}
+
+	cl, err := minio.New(conf.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(conf.AccessKey, conf.SecretKey, ""),
+		Secure: !conf.Insecure,
+		Region: conf.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create s3 client: %w", err)
+	}
+
+	prefix := strings.Trim(conf.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	return &S3Bucket{
+		cl:     cl,
+		bucket: conf.Bucket,
+		prefix: prefix,
+	}, nil
+}
+
+func (s *S3Bucket) key(name string) string {
+	return s.prefix + name
+}
+
+// Put uploads the object, which becomes visible only once the upload completes
+func (s *S3Bucket) Put(ctx context.Context, name string, r io.Reader) error {
+	// the size is passed when it is known, as otherwise the object is uploaded in large buffered parts
+	size := int64(-1)
+
+	switch v := r.(type) {
+	case interface{ Len() int }:
+		size = int64(v.Len())
+	case *os.File:
+		if info, err := v.Stat(); err == nil {
+			size = info.Size()
+		}
+	}
+
+	if _, err := s.cl.PutObject(ctx, s.bucket, s.key(name), r, size, minio.PutObjectOptions{}); err != nil {
+		return fmt.Errorf("could not upload object: %w", err)
+	}
+
+	return nil
+}
+
+func (s *S3Bucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	// GetObject does not fail on missing objects, so stat is used to check it exists
+	if _, err := s.cl.StatObject(ctx, s.bucket, s.key(name), minio.StatObjectOptions{}); err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+		}
+
+		return nil, fmt.Errorf("could not stat object: %w", err)
+	}
+
+	obj, err := s.cl.GetObject(ctx, s.bucket, s.key(name), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not get object: %w", err)
+	}
+
+	return obj, nil
+}
+
+func (s *S3Bucket) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+
+	for obj := range s.cl.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    s.key(prefix),
+		Recursive: true,
+	}) {
+		if obj.Err != nil {
+			return nil, fmt.Errorf("could not list objects: %w", obj.Err)
+		}
+
+		objects = append(objects, Object{
+			Name:    strings.TrimPrefix(obj.Key, s.prefix),
+			Size:    obj.Size,
+			ModTime: obj.LastModified,
+		})
+	}
+
+	return objects, nil
+}
+
+func (s *S3Bucket) Delete(ctx context.Context, name string) error {
+	if err := s.cl.RemoveObject(ctx, s.bucket, s.key(name), minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("could not delete object: %w", err)
+	}
+
+	return nil
+}
+
+// Touch copies the object onto itself, as object modification time can not be changed otherwise
+func (s *S3Bucket) Touch(ctx context.Context, name string) error {
+	if _, err := s.cl.CopyObject(ctx, minio.CopyDestOptions{
+		Bucket:          s.bucket,
+		Object:          s.key(name),
+		ReplaceMetadata: true,
+		UserMetadata:    map[string]string{"gombak-touched": time.Now().UTC().Format(time.RFC3339)},
+	}, minio.CopySrcOptions{
+		Bucket: s.bucket,
+		Object: s.key(name),
+	}); err != nil {
+		return fmt.Errorf("could not touch object: %w", err)
+	}
+
+	return nil
+}
+
+func (s *S3Bucket) Location(name string) string {
+	return "s3://" + path.Join(s.bucket, s.key(name))
+}
+
+func (s *S3Bucket) Close() error {
+	return nil
+}
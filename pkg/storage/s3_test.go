@@ -0,0 +1,298 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is an in-memory S3 server for a single bucket, handling the requests S3Bucket makes
+type fakeS3 struct {
+	bucket string
+
+	mu      sync.Mutex
+	objects map[string]fakeObject
+}
+
+type fakeObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+func (o fakeObject) etag() string {
+	sum := md5.Sum(o.data)
+
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		f.error(w, r, http.StatusNotFound, "NoSuchBucket")
+
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		f.list(w, r.URL.Query().Get("prefix"))
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		f.copy(w, r, key)
+	case r.Method == http.MethodPut:
+		f.put(w, r, key)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		obj, ok := f.objects[key]
+		if !ok {
+			f.error(w, r, http.StatusNotFound, "NoSuchKey")
+
+			return
+		}
+
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+		w.Header().Set("Last-Modified", obj.modTime.UTC().Format(http.TimeFormat))
+		w.Header().Set("ETag", obj.etag())
+
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(obj.data)
+		}
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		f.error(w, r, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (f *fakeS3) put(w http.ResponseWriter, r *http.Request, key string) {
+	var (
+		data []byte
+		err  error
+	)
+
+	// the client signs each chunk of the body when it connects over plain http
+	if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		data, err = readChunked(r.Body)
+	} else {
+		data, err = io.ReadAll(r.Body)
+	}
+
+	if err != nil {
+		f.error(w, r, http.StatusBadRequest, "IncompleteBody")
+
+		return
+	}
+
+	obj := fakeObject{data: data, modTime: time.Now()}
+	f.objects[key] = obj
+
+	w.Header().Set("ETag", obj.etag())
+}
+
+func (f *fakeS3) copy(w http.ResponseWriter, r *http.Request, key string) {
+	// the copy source is the escaped bucket and key path
+	src, err := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+	if err != nil {
+		f.error(w, r, http.StatusBadRequest, "InvalidArgument")
+
+		return
+	}
+
+	obj, ok := f.objects[strings.TrimPrefix(strings.TrimPrefix(src, "/"), f.bucket+"/")]
+	if !ok {
+		f.error(w, r, http.StatusNotFound, "NoSuchKey")
+
+		return
+	}
+
+	obj.modTime = time.Now()
+	f.objects[key] = obj
+
+	writeXML(w, struct {
+		XMLName      xml.Name `xml:"CopyObjectResult"`
+		LastModified string
+		ETag         string
+	}{
+		LastModified: obj.modTime.UTC().Format(time.RFC3339),
+		ETag:         obj.etag(),
+	})
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, prefix string) {
+	type content struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int64
+		StorageClass string
+	}
+
+	var contents []content
+
+	for key, obj := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			contents = append(contents, content{
+				Key:          key,
+				LastModified: obj.modTime.UTC().Format(time.RFC3339),
+				ETag:         obj.etag(),
+				Size:         int64(len(obj.data)),
+				StorageClass: "STANDARD",
+			})
+		}
+	}
+
+	sort.Slice(contents, func(i, j int) bool {
+		return contents[i].Key < contents[j].Key
+	})
+
+	writeXML(w, struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		Name        string
+		Prefix      string
+		KeyCount    int
+		MaxKeys     int
+		IsTruncated bool
+		Contents    []content
+	}{
+		Name:     f.bucket,
+		Prefix:   prefix,
+		KeyCount: len(contents),
+		MaxKeys:  1000,
+		Contents: contents,
+	})
+}
+
+func (f *fakeS3) error(w http.ResponseWriter, r *http.Request, status int, code string) {
+	w.WriteHeader(status)
+
+	// the head responses have no body
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	writeXML(w, struct {
+		XMLName  xml.Name `xml:"Error"`
+		Code     string
+		Resource string
+	}{
+		Code:     code,
+		Resource: r.URL.Path,
+	})
+}
+
+func writeXML(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+
+	_ = xml.NewEncoder(w).Encode(v)
+}
+
+// readChunked decodes the aws-chunked body, made of size;chunk-signature headers followed by the chunk data
+func readChunked(r io.Reader) ([]byte, error) {
+	var (
+		data bytes.Buffer
+		br   = bufio.NewReader(r)
+	)
+
+	for {
+		header, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(header), ";")
+
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk size %q: %w", sizeHex, err)
+		}
+
+		if size == 0 {
+			return data.Bytes(), nil
+		}
+
+		if _, err = io.CopyN(&data, br, size); err != nil {
+			return nil, err
+		}
+
+		// the chunk data ends with a new line
+		if _, err = br.Discard(2); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func newFakeS3Bucket(t *testing.T, prefix string) (*S3Bucket, *fakeS3) {
+	t.Helper()
+
+	fake := &fakeS3{
+		bucket:  "backups",
+		objects: make(map[string]fakeObject),
+	}
+
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	store, err := NewS3Bucket(S3Config{
+		Endpoint:  strings.TrimPrefix(srv.URL, "http://"),
+		Region:    "us-east-1",
+		Bucket:    fake.bucket,
+		Prefix:    prefix,
+		AccessKey: "access",
+		SecretKey: "secret",
+		Insecure:  true,
+	})
+	if err != nil {
+		t.Fatalf("NewS3Bucket() error: %v", err)
+	}
+
+	return store, fake
+}
+
+func TestS3Bucket(t *testing.T) {
+	for _, prefix := range []string{"", "/gombak/"} {
+		t.Run("prefix "+prefix, func(t *testing.T) {
+			store, fake := newFakeS3Bucket(t, prefix)
+
+			testRoundTrip(t, store, func(name string, modTime time.Time) {
+				fake.mu.Lock()
+				defer fake.mu.Unlock()
+
+				obj := fake.objects[store.key(name)]
+				obj.modTime = modTime
+				fake.objects[store.key(name)] = obj
+			})
+		})
+	}
+}
+
+func TestS3BucketPrefix(t *testing.T) {
+	store, fake := newFakeS3Bucket(t, "/gombak/")
+
+	if err := store.Put(context.Background(), "router1-2024-01-01.rsc", strings.NewReader("export")); err != nil {
+		t.Fatalf("Put() error: %v", err)
+	}
+
+	if _, ok := fake.objects["gombak/router1-2024-01-01.rsc"]; !ok {
+		t.Errorf("Put() stored %v, want gombak/router1-2024-01-01.rsc", fake.objects)
+	}
+
+	if got, want := store.Location("router1-2024-01-01.rsc"), "s3://backups/gombak/router1-2024-01-01.rsc"; got != want {
+		t.Errorf("Location() = %q, want %q", got, want)
+	}
+}
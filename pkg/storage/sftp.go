@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	sshclient "github.com/ZeljkoBenovic/gombak/pkg/ssh"
+	"github.com/pkg/sftp"
+)
+
+// SFTPConfig holds the remote sftp server settings.
+// The auth method and host key verification are set using ssh client options.
+type SFTPConfig struct {
+	Host string
+	Port string
+	User string
+	Dir  string
+}
+
+// SFTPDir stores backups in a directory on a remote sftp server
+type SFTPDir struct {
+	cl   *sshclient.SSH
+	sftp *sftp.Client
+	host string
+	dir  string
+}
+
+func NewSFTPDir(ctx context.Context, conf SFTPConfig, opts ...sshclient.ClientOpts) (*SFTPDir, error) {
+	if conf.Dir == "" {
+		return nil, fmt.Errorf("sftp storage dir not set")
+	}
+
+	cl, err := sshclient.NewSSH(ctx, conf.User, conf.Host, conf.Port, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to sftp storage: %w", err)
+	}
+
+	sftpCl, err := cl.SFTP()
+	if err != nil {
+		_ = cl.Close()
+		return nil, err
+	}
+
+	return &SFTPDir{
+		cl:   cl,
+		sftp: sftpCl,
+		host: conf.Host,
+		dir:  conf.Dir,
+	}, nil
+}
+
+func (s *SFTPDir) path(name string) string {
+	return path.Join(s.dir, name)
+}
+
+// Put writes to a temporary file first, which is renamed to the object path only if the whole content is written
+func (s *SFTPDir) Put(ctx context.Context, name string, r io.Reader) error {
+	to := s.path(name)
+
+	if err := s.sftp.MkdirAll(path.Dir(to)); err != nil {
+		return fmt.Errorf("could not create backup dir: %w", err)
+	}
+
+	tmp := path.Join(path.Dir(to), fmt.Sprintf(".%s.%d.part", path.Base(to), time.Now().UnixNano()))
+
+	remote, err := s.sftp.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("could not create new file: %w", err)
+	}
+
+	// the temporary file is removed unless it was renamed to the final path
+	defer func() {
+		_ = remote.Close()
+		_ = s.sftp.Remove(tmp)
+	}()
+
+	stop := context.AfterFunc(ctx, func() {
+		_ = remote.Close()
+	})
+	defer stop()
+
+	if _, err = remote.ReadFrom(r); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("upload aborted: %w", ctx.Err())
+		}
+
+		return fmt.Errorf("could not write file: %w", err)
+	}
+
+	if err = remote.Close(); err != nil {
+		return fmt.Errorf("could not close file: %w", err)
+	}
+
+	if err = s.sftp.PosixRename(tmp, to); err != nil {
+		return fmt.Errorf("could not move file: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SFTPDir) Get(_ context.Context, name string) (io.ReadCloser, error) {
+	f, err := s.sftp.Open(s.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not open file: %w", err)
+	}
+
+	return f, nil
+}
+
+// List skips the temporary files of writes in progress
+func (s *SFTPDir) List(_ context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+
+	walker := s.sftp.Walk(s.dir)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			if errors.Is(err, os.ErrNotExist) && walker.Path() == s.dir {
+				return nil, nil
+			}
+
+			return nil, fmt.Errorf("could not list backup dir: %w", err)
+		}
+
+		fi := walker.Stat()
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+
+		name := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), s.dir), "/")
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		objects = append(objects, Object{
+			Name:    name,
+			Size:    fi.Size(),
+			ModTime: fi.ModTime(),
+		})
+	}
+
+	return objects, nil
+}
+
+// Delete removes the file, and the directories left empty by its removal
+func (s *SFTPDir) Delete(_ context.Context, name string) error {
+	if err := s.sftp.Remove(s.path(name)); err != nil {
+		return err
+	}
+
+	// removing a directory fails if it is not empty
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if s.sftp.RemoveDirectory(s.path(dir)) != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (s *SFTPDir) Touch(_ context.Context, name string) error {
+	now := time.Now()
+
+	return s.sftp.Chtimes(s.path(name), now, now)
+}
+
+func (s *SFTPDir) Location(name string) string {
+	return "sftp://" + s.host + "/" + strings.TrimPrefix(s.path(name), "/")
+}
+
+func (s *SFTPDir) Close() error {
+	return s.cl.Close()
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+// Object is a file stored in the backup storage
+type Object struct {
+	// Name is the slash separated path relative to the storage root
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage is a backup storage backend
+type Storage interface {
+	// Put stores the content under the name, replacing the existing object.
+	// The object is visible only once the whole content is stored.
+	Put(ctx context.Context, name string, r io.Reader) error
+	// Get returns the object content. It returns ErrNotFound if the object does not exist.
+	Get(ctx context.Context, name string) (io.ReadCloser, error)
+	// List returns all objects which names start with the prefix, in all subdirectories
+	List(ctx context.Context, prefix string) ([]Object, error)
+	// Delete removes the object
+	Delete(ctx context.Context, name string) error
+	// Touch sets the object modification time to now
+	Touch(ctx context.Context, name string) error
+	// Location returns the full location of the object, used in logs and reports
+	Location(name string) string
+	// Close releases the storage connection
+	Close() error
+}
+
+type Type string
+
+const (
+	Local Type = "local"
+	S3    Type = "s3"
+	SFTP  Type = "sftp"
+)
+
+var Types = map[string]Type{
+	"local": Local,
+	"s3":    S3,
+	"sftp":  SFTP,
+}
+
+// ReadAll returns the whole object content
+func ReadAll(ctx context.Context, s Storage, name string) ([]byte, error) {
+	r, err := s.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testRoundTrip puts, gets, lists, touches and deletes the objects in the storage.
+// The backdate func sets the object modification time, so the touch can be checked.
+func testRoundTrip(t *testing.T, s Storage, backdate func(name string, modTime time.Time)) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	objects := map[string]string{
+		"router1-2024-01-01.rsc":        "/ip address\nadd address=10.0.0.1/24 interface=ether1\n",
+		"router1-2024-01-01.rsc.sha256": "checksum",
+		"core/router2-2024-01-01.rsc":   "/system identity\nset name=router2\n",
+		"runs/20240101T100000-1a2b3c":   "{}",
+	}
+
+	for name, content := range objects {
+		if err := s.Put(ctx, name, strings.NewReader(content)); err != nil {
+			t.Fatalf("Put(%q) error: %v", name, err)
+		}
+	}
+
+	for name, content := range objects {
+		got, err := ReadAll(ctx, s, name)
+		if err != nil {
+			t.Fatalf("Get(%q) error: %v", name, err)
+		}
+
+		if string(got) != content {
+			t.Errorf("Get(%q) = %q, want %q", name, got, content)
+		}
+	}
+
+	if _, err := s.Get(ctx, "router3-2024-01-01.rsc"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() of a missing object error = %v, want %v", err, ErrNotFound)
+	}
+
+	// a new put replaces the object
+	replaced := "/system identity\nset name=router1\n"
+	if err := s.Put(ctx, "router1-2024-01-01.rsc", strings.NewReader(replaced)); err != nil {
+		t.Fatalf("Put() error: %v", err)
+	}
+
+	objects["router1-2024-01-01.rsc"] = replaced
+
+	if got, err := ReadAll(ctx, s, "router1-2024-01-01.rsc"); err != nil || string(got) != replaced {
+		t.Errorf("Get() of the replaced object = %q, %v, want %q", got, err, replaced)
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{prefix: "", want: []string{"core/router2-2024-01-01.rsc", "router1-2024-01-01.rsc", "router1-2024-01-01.rsc.sha256", "runs/20240101T100000-1a2b3c"}},
+		{prefix: "router1-", want: []string{"router1-2024-01-01.rsc", "router1-2024-01-01.rsc.sha256"}},
+		{prefix: "core/", want: []string{"core/router2-2024-01-01.rsc"}},
+		{prefix: "router3-"},
+	}
+
+	for _, tt := range tests {
+		listed, err := s.List(ctx, tt.prefix)
+		if err != nil {
+			t.Fatalf("List(%q) error: %v", tt.prefix, err)
+		}
+
+		var got []string
+
+		for _, obj := range listed {
+			got = append(got, obj.Name)
+
+			if obj.Size != int64(len(objects[obj.Name])) {
+				t.Errorf("List(%q) size of %s = %d, want %d", tt.prefix, obj.Name, obj.Size, len(objects[obj.Name]))
+			}
+		}
+
+		sort.Strings(got)
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("List(%q) = %v, want %v", tt.prefix, got, tt.want)
+		}
+	}
+
+	old := time.Now().AddDate(0, 0, -30)
+	backdate("core/router2-2024-01-01.rsc", old)
+
+	if err := s.Touch(ctx, "core/router2-2024-01-01.rsc"); err != nil {
+		t.Fatalf("Touch() error: %v", err)
+	}
+
+	listed, err := s.List(ctx, "core/")
+	if err != nil {
+		t.Fatalf("List() error: %v", err)
+	}
+
+	if len(listed) != 1 || time.Since(listed[0].ModTime) > time.Hour {
+		t.Errorf("List() after Touch() = %+v, want modification time now", listed)
+	}
+
+	for name := range objects {
+		if err := s.Delete(ctx, name); err != nil {
+			t.Fatalf("Delete(%q) error: %v", name, err)
+		}
+	}
+
+	if listed, err = s.List(ctx, ""); err != nil || len(listed) != 0 {
+		t.Errorf("List() after Delete() = %+v, %v, want no objects", listed, err)
+	}
+}